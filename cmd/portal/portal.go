@@ -47,6 +47,20 @@ var startCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalln(err)
 		}
+		if err := mg.EnsureJobIndexes(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mg.EnsureTxIndexes(); err != nil {
+			log.Fatalln(err)
+		}
+		if err := mg.EnsureRefundIndexes(); err != nil {
+			log.Fatalln(err)
+		}
+		migrated, err := mg.MigrateTxAmounts()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("Migrated", migrated, "swap amounts to decimal strings")
 
 		err = logger.SetLogFile(logger.LogFileName)
 		if err != nil {
@@ -65,9 +79,15 @@ var startCmd = &cobra.Command{
 		}
 
 		go kConn.HandleMessage()
-		go bscConn.FillInputData()
+		go kConn.ProcessPayouts()
 		go bscConn.StoreTransactions()
 		go bscConn.HandleMessage()
+		go bscConn.ProcessPayouts()
+		go bscConn.Sweep()
+		go bscConn.WatchDisbursements()
+		go kConn.WatchDisbursements()
+		go bscConn.ProcessRefunds()
+		go kConn.ProcessRefunds()
 		log.Println("****************** Portal server started")
 		go func(msg chan string) {
 			for {
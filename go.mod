@@ -3,11 +3,11 @@ module github.com/konstellation/swap
 go 1.16
 
 require (
+	github.com/99designs/keyring v1.1.6
 	github.com/asaskevich/govalidator v0.0.0-20200108200545-475eaeb16496 // indirect
 	github.com/cosmos/cosmos-sdk v0.43.0
 	github.com/cosmos/go-bip39 v1.0.0
 	github.com/ethereum/go-ethereum v1.10.12
-	github.com/gammazero/deque v0.1.0
 	github.com/go-ozzo/ozzo-validation v3.6.0+incompatible
 	github.com/golang/protobuf v1.5.2
 	github.com/google/go-cmp v0.5.6 // indirect
@@ -3,221 +3,688 @@ package chain
 import (
 	"context"
 	"crypto/ecdsa"
-	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"math/big"
-	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/gorilla/websocket"
 	"github.com/konstellation/swap/internal/config"
+	keyring "github.com/konstellation/swap/internal/key"
 	"github.com/konstellation/swap/internal/model"
 	"github.com/konstellation/swap/internal/mongo"
 	BEP20Token "github.com/konstellation/swap/internal/types"
 	"github.com/konstellation/swap/internal/util"
 	"github.com/shopspring/decimal"
-	"golang.org/x/crypto/sha3"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/gammazero/deque"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 )
 
+// payout tx types of BSC_TX_TYPE
 const (
-	amountBscUnit      = "1000000000000000000"
-	userTransactionFee = "0.0001"
+	txTypeLegacy  = "legacy"
+	txTypeDynamic = "dynamic"
+
+	minGasBumpPercent = 10.0
+)
+
+var (
+	// errNotFinal is returned while a matched deposit waits for the confirmation depth
+	errNotFinal = fmt.Errorf("deposit is not deep enough yet")
+	// errConfirmTimeout is returned when a payout is not confirmed before the deadline
+	errConfirmTimeout = fmt.Errorf("payout is not confirmed before the deadline")
+	// errNotMined is returned when a payout stays in the mempool for too long
+	errNotMined = fmt.Errorf("payout is not mined yet")
 )
 
+// transferSig is the topic of the BEP20 Transfer event
+var transferSig = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
 type BSCConnection struct {
-	client                *ethclient.Client
-	konConn               *KnstlConnection
-	MongoDB               *mongo.Connection
-	headerChan            chan *types.Header
-	logChan               chan types.Log
-	ctx                   context.Context
-	sub                   ethereum.Subscription
-	msgChan               chan string
-	transactionScanApiUrl string
-	InputChan             chan *model.Tx
-	q                     deque.Deque
+	mu               sync.RWMutex
+	client           *ethclient.Client
+	endpoints        *endpointPool
+	subscription     *subscriptionHealth
+	konConn          *KnstlConnection
+	MongoDB          *mongo.Connection
+	headerChan       chan *types.Header
+	logChan          chan types.Log
+	ctx              context.Context
+	sub              ethereum.Subscription
+	msgChan          chan string
+	startBlock       uint64
+	confirmations    uint64
+	gasMarginPercent uint64
+	confirmTimeout   time.Duration
+	stuckAfter       time.Duration
+	gasBumpPercent   float64
+	gasPriceCeiling  *big.Int
 
 	signer           types.Signer
+	chainID          *big.Int
+	txType           string
+	gasFeeCap        *big.Int
+	gasTipCap        *big.Int
 	corporateAddress common.Address
 	contractAddress  common.Address
 	privKey          *ecdsa.PrivateKey
 	pubKey           *ecdsa.PublicKey
 	contractAbi      abi.ABI
+	nonces           *nonceManager
+	deposits         *depositWallet
+	payouts          *payoutGuard
+	sweepInterval    time.Duration
 }
 
 type TransactionData struct {
 	SwapNonce int `json:"swap_nonce"`
 }
 
-func (b *BSCConnection) InitConnection(ctx context.Context, c *config.BscInfo, mg *mongo.Connection, konConn *KnstlConnection, msgChan chan string) error {
-	var err error
-	b.client, err = ethclient.Dial(c.BscNodeUrl)
-	if err != nil {
-		log.Fatalln(err)
+// loadSigner loads the corporate key from the keystore file, or from the hex private key when
+// no keystore is set, and checks that it signs for BSC_CORPORATE_ADDR
+func (b *BSCConnection) loadSigner(c *config.BscInfo) error {
+	if c.BscKeystoreFile != "" {
+		keyJSON, err := os.ReadFile(c.BscKeystoreFile)
+		if err != nil {
+			return fmt.Errorf("failed to read keystore: %v", err)
+		}
+		passphrase, err := keyring.ReadPassphrase(0, c.BscKeystorePassphraseFile)
+		if err != nil {
+			return err
+		}
+		key, err := keystore.DecryptKey(keyJSON, passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt keystore: %v", err)
+		}
+		b.privKey = key.PrivateKey
+	} else if c.BscCorporateAddrPrivKey != "" {
+		log.Println("BSC: signing with the plaintext BSC_CORPORATE_ADDR_PRIV_KEY, use a keystore outside development")
+		privKey, err := crypto.HexToECDSA(c.BscCorporateAddrPrivKey)
+		if err != nil {
+			return err
+		}
+		b.privKey = privKey
+	} else {
+		return fmt.Errorf("neither a keystore nor a private key is set")
 	}
-	log.Println("BSC node connected:", c.BscNodeUrl)
+	b.pubKey = (b.privKey.Public()).(*ecdsa.PublicKey)
+	if address := crypto.PubkeyToAddress(*b.pubKey); address != b.corporateAddress {
+		return fmt.Errorf("signer address %s does not match BSC_CORPORATE_ADDR %s", address.Hex(), c.BscCorporateAddr)
+	}
+	return nil
+}
 
+func (b *BSCConnection) InitConnection(ctx context.Context, c *config.BscInfo, mg *mongo.Connection, konConn *KnstlConnection, msgChan chan string) error {
+	var err error
 	b.ctx = context.Background()
 
 	b.corporateAddress = common.HexToAddress(c.BscCorporateAddr)
 	b.contractAddress = common.HexToAddress(c.BEP20ContractAddr)
 	b.konConn = konConn
 	b.logChan = make(chan types.Log)
-	b.InputChan = make(chan *model.Tx)
 	b.msgChan = msgChan
 	b.MongoDB = mg
-	b.transactionScanApiUrl = c.BscTransactionScanApiUrl
+	b.startBlock = c.BscStartBlock
+	b.confirmations = c.BscConfirmations
+	b.gasMarginPercent = c.BscGasMarginPercent
+	b.payouts = newPayoutGuard()
+	b.sweepInterval = time.Duration(c.BscSweepMinutes) * time.Minute
 
-	query := ethereum.FilterQuery{
-		Addresses: []common.Address{b.contractAddress},
+	b.subscription = newSubscriptionHealth("bsc_subscription")
+	b.endpoints, err = newEndpointPool("BSC", c.BscNodeUrls, c.BscMaxHeadLag, probeBscNode)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := b.connectBest(); err != nil {
+		log.Fatalln(err)
 	}
 
-	sub, err := b.client.SubscribeFilterLogs(ctx, query, b.logChan)
+	if err := b.loadSigner(c); err != nil {
+		log.Fatalln("BSC: ", err)
+	}
+	b.nonces = newNonceManager(b.ctx, b.node, b.MongoDB, crypto.PubkeyToAddress(*b.pubKey))
+	if err := b.loadChainID(c); err != nil {
+		log.Fatalln(err)
+	}
+	b.signer = types.LatestSignerForChainID(b.chainID)
+	if err := b.loadFeeConfig(c); err != nil {
+		log.Fatalln(err)
+	}
+	if err := b.loadPayoutConfig(c); err != nil {
+		log.Fatalln(err)
+	}
+	if err := b.loadDepositWallet(c.BscDepositMnemonicFile); err != nil {
+		log.Fatalln(err)
+	}
+
+	contractAbi, err := abi.JSON(strings.NewReader(BEP20Token.BEP20TokenMetaData.ABI))
 	if err != nil {
 		log.Fatal(err)
 	}
+	b.contractAbi = contractAbi
+
+	if err := b.loadTokenInfo(c); err != nil {
+		log.Fatalln(err)
+	}
+
+	return nil
+}
+
+// node returns the client of the active endpoint
+func (b *BSCConnection) node() *ethclient.Client {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.client
+}
+
+// Endpoints reports the state of the bsc node endpoints
+func (b *BSCConnection) Endpoints() EndpointsStatus {
+	return b.endpoints.Status()
+}
+
+// Subscription reports the health of the token log subscription
+func (b *BSCConnection) Subscription() SubscriptionStatus {
+	return b.subscription.Status()
+}
+
+// probeBscNode returns the latest block of a bsc node
+func probeBscNode(ctx context.Context, url string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, util.EndpointProbeTimeoutSeconds*time.Second)
+	defer cancel()
+	client, err := ethclient.DialContext(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+	head, err := client.BlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int64(head), nil
+}
+
+// connectBest probes the endpoints and connects to the active one,
+// failing over to the next healthy endpoint when the connection fails
+func (b *BSCConnection) connectBest() error {
+	if _, err := b.endpoints.Probe(b.ctx); err != nil {
+		return err
+	}
+	url := b.endpoints.Active()
+	for {
+		err := b.connect(url)
+		if err == nil {
+			return nil
+		}
+		log.Println("BSC: failed to connect to", redactURL(url), err)
+		if url, err = b.endpoints.MarkDown(url, err); err != nil {
+			return err
+		}
+	}
+}
+
+// connect dials the endpoint, subscribes to the token logs on it and replaces the active client
+func (b *BSCConnection) connect(url string) error {
+	client, err := ethclient.DialContext(b.ctx, url)
+	if err != nil {
+		return err
+	}
+	if b.chainID != nil { // a failover node must serve the pinned chain
+		nodeChainID, err := client.ChainID(b.ctx)
+		if err != nil {
+			client.Close()
+			return err
+		}
+		if nodeChainID.Cmp(b.chainID) != 0 {
+			client.Close()
+			return fmt.Errorf("node serves chain %s, expected %s", nodeChainID, b.chainID)
+		}
+	}
+	query := b.logQuery()
+	sub, err := client.SubscribeFilterLogs(b.ctx, query, b.logChan)
+	if err != nil {
+		client.Close()
+		return err
+	}
 
+	b.mu.Lock()
+	oldClient, oldSub := b.client, b.sub
+	b.client, b.sub = client, sub
+	b.mu.Unlock()
+	if oldSub != nil {
+		oldSub.Unsubscribe()
+	}
+	if oldClient != nil {
+		oldClient.Close()
+	}
+	b.subscription.Connected()
+	log.Println("BSC node connected:", redactURL(url))
 	log.Printf("BSC: subscribed to %s\n", query.Addresses)
+	return nil
+}
 
-	b.sub = sub
+// reconnect connects to the best endpoint with exponential backoff until it succeeds,
+// then backfills the logs missed meanwhile. Keys and ABI are kept.
+func (b *BSCConnection) reconnect() {
+	for attempt := 0; ; attempt++ {
+		err := b.connectBest()
+		if err == nil {
+			break
+		}
+		b.subscription.Failed(err)
+		delay := backoffDelay(attempt)
+		log.Println("BSC: reconnect failed:", err, "retrying in", delay)
+		time.Sleep(delay)
+	}
+	if err := b.Backfill(); err != nil {
+		log.Println(err)
+	}
+}
 
-	b.privKey, err = crypto.HexToECDSA(c.BscCorporateAddrPrivKey)
-	// b.privKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// loadChainID pins the configured chain id and checks that the node serves the same chain
+func (b *BSCConnection) loadChainID(c *config.BscInfo) error {
+	nodeChainID, err := b.node().ChainID(b.ctx)
 	if err != nil {
-		log.Fatalln(err)
+		return fmt.Errorf("bsc chain id: %v", err)
 	}
-	b.pubKey = (b.privKey.Public()).(*ecdsa.PublicKey)
-	b.signer = types.NewEIP155Signer(big.NewInt(1000))
+	if c.BscChainID == "" {
+		return fmt.Errorf("BSC_CHAIN_ID is not set, the node serves chain %s", nodeChainID)
+	}
+	chainID, ok := new(big.Int).SetString(c.BscChainID, 10)
+	if !ok {
+		return fmt.Errorf("invalid BSC_CHAIN_ID %s", c.BscChainID)
+	}
+	if chainID.Cmp(nodeChainID) != 0 {
+		return fmt.Errorf("bsc node serves chain %s, expected %s", nodeChainID, chainID)
+	}
+	log.Println("BSC: chain id", chainID)
+	b.chainID = chainID
+	return nil
+}
 
-	contractAbi, err := abi.JSON(strings.NewReader(BEP20Token.BEP20TokenMetaData.ABI))
+// loadFeeConfig reads the tx type and the optional fee caps in wei
+func (b *BSCConnection) loadFeeConfig(c *config.BscInfo) error {
+	switch c.BscTxType {
+	case "", txTypeLegacy:
+		b.txType = txTypeLegacy
+	case txTypeDynamic:
+		b.txType = txTypeDynamic
+	default:
+		return fmt.Errorf("invalid BSC_TX_TYPE %s", c.BscTxType)
+	}
+	b.gasFeeCap, b.gasTipCap, b.gasPriceCeiling = nil, nil, nil
+	if c.BscGasPriceCeiling != "" {
+		ceiling, ok := new(big.Int).SetString(c.BscGasPriceCeiling, 10)
+		if !ok {
+			return fmt.Errorf("invalid BSC_GAS_PRICE_CEILING %s", c.BscGasPriceCeiling)
+		}
+		b.gasPriceCeiling = ceiling
+	}
+	if c.BscGasFeeCap != "" {
+		feeCap, ok := new(big.Int).SetString(c.BscGasFeeCap, 10)
+		if !ok {
+			return fmt.Errorf("invalid BSC_GAS_FEE_CAP %s", c.BscGasFeeCap)
+		}
+		b.gasFeeCap = feeCap
+	}
+	if c.BscGasTipCap != "" {
+		tipCap, ok := new(big.Int).SetString(c.BscGasTipCap, 10)
+		if !ok {
+			return fmt.Errorf("invalid BSC_GAS_TIP_CAP %s", c.BscGasTipCap)
+		}
+		b.gasTipCap = tipCap
+	}
+	log.Println("BSC: payouts use", b.txType, "txs")
+	return nil
+}
+
+// loadPayoutConfig reads how long a payout is waited for and replaced while it is not mined
+func (b *BSCConnection) loadPayoutConfig(c *config.BscInfo) error {
+	if c.BscConfirmTimeoutMinutes <= 0 {
+		return fmt.Errorf("invalid BSC_CONFIRM_TIMEOUT_MINUTES %d, must be positive", c.BscConfirmTimeoutMinutes)
+	}
+	if c.BscStuckMinutes <= 0 {
+		return fmt.Errorf("invalid BSC_STUCK_MINUTES %d, must be positive", c.BscStuckMinutes)
+	}
+	// nodes refuse a replacement that does not raise the fees by at least 10%
+	if c.BscGasBumpPercent < minGasBumpPercent {
+		return fmt.Errorf("invalid BSC_GAS_BUMP_PERCENT %v, must be at least %v", c.BscGasBumpPercent, minGasBumpPercent)
+	}
+	b.confirmTimeout = time.Duration(c.BscConfirmTimeoutMinutes) * time.Minute
+	b.stuckAfter = time.Duration(c.BscStuckMinutes) * time.Minute
+	b.gasBumpPercent = c.BscGasBumpPercent
+	log.Println("BSC: payouts are confirmed within", b.confirmTimeout, "and replaced with", b.gasBumpPercent, "% higher fees after", b.stuckAfter)
+	return nil
+}
+
+// loadTokenInfo reads the token metadata from the contract and checks it against the config
+func (b *BSCConnection) loadTokenInfo(c *config.BscInfo) error {
+	token, err := BEP20Token.NewBEP20TokenCaller(b.contractAddress, b.node())
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	b.contractAbi = contractAbi
+	opts := &bind.CallOpts{Context: b.ctx}
+	name, err := token.Name(opts)
+	if err != nil {
+		return fmt.Errorf("bsc token name: %v", err)
+	}
+	symbol, err := token.Symbol(opts)
+	if err != nil {
+		return fmt.Errorf("bsc token symbol: %v", err)
+	}
+	decimals, err := token.Decimals(opts)
+	if err != nil {
+		return fmt.Errorf("bsc token decimals: %v", err)
+	}
+	log.Printf("BSC: token %s (%s) with %d decimals\n", name, symbol, decimals)
 
+	if c.BscTokenName != "" && c.BscTokenName != name {
+		return fmt.Errorf("bsc token name is %s, expected %s", name, c.BscTokenName)
+	}
+	if c.BscTokenSymbol != "" && c.BscTokenSymbol != symbol {
+		return fmt.Errorf("bsc token symbol is %s, expected %s", symbol, c.BscTokenSymbol)
+	}
+	if c.BscTokenDecimals != 0 && c.BscTokenDecimals != int(decimals) {
+		return fmt.Errorf("bsc token has %d decimals, expected %d", decimals, c.BscTokenDecimals)
+	}
+	util.AmountBscDecimals = int32(decimals)
 	return nil
 }
 
-func (b *BSCConnection) FillInputData() {
-	for {
-		select {
-		case inputData := <-b.InputChan:
-			b.q.PushBack(inputData)
+// Enqueue persists a bsc->knstl swap request in the job queue
+func (b *BSCConnection) Enqueue(tx *model.Tx) error {
+	_, err := b.MongoDB.EnqueueJob(model.NewJob(model.QueueBscSwap, tx.ID, util.JobMaxAttempts))
+	return err
+}
+
+// ResumeSwaps makes sure every bsc->knstl swap still waiting for its deposit has a job in the queue
+func (b *BSCConnection) ResumeSwaps() error {
+	filter := map[string]string{
+		"source_network":           "bsc",
+		"completed":                "false",
+		"source_network_completed": "false",
+	}
+	txs, err := b.MongoDB.FindTxs(filter)
+	if err != nil {
+		return err
+	}
+	for i := range txs {
+		if err := b.Enqueue(&txs[i]); err != nil {
+			return err
+		}
+	}
+	log.Println("BSC: resumed", len(txs), "unfinished swaps")
+	return nil
+}
+
+// logQuery selects the Transfer logs of the token, its other events are not deposits
+func (b *BSCConnection) logQuery() ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		Addresses: []common.Address{b.contractAddress},
+		Topics:    [][]common.Hash{{transferSig}},
+	}
+}
+
+// isTransferLog reports whether the log is a Transfer with the indexed sender and recipient
+func isTransferLog(vLog *types.Log) bool {
+	return len(vLog.Topics) == 3 && vLog.Topics[0] == transferSig
+}
+
+// Backfill stores the Transfer logs from the saved block cursor up to the chain head.
+// Live logs buffered by the subscription meanwhile are handled afterwards.
+func (b *BSCConnection) Backfill() error {
+	head, err := b.node().BlockNumber(b.ctx)
+	if err != nil {
+		return err
+	}
+	from := b.startBlock
+	cursor, err := b.MongoDB.GetCursor(model.CursorBscLogs)
+	if err == nil {
+		from = cursor.Height
+	} else if err != mongodrv.ErrNoDocuments {
+		return err
+	}
+	if from == 0 { // first run without BSC_START_BLOCK, start from the head
+		log.Println("BSC: no block cursor, starting from block", head)
+		return b.MongoDB.SaveCursor(model.CursorBscLogs, head)
+	}
+
+	log.Println("BSC: backfilling logs from block", from, "to", head)
+	for start := from; start <= head; start += util.BscBackfillBlockRange {
+		end := start + util.BscBackfillBlockRange - 1
+		if end > head {
+			end = head
+		}
+		query := b.logQuery()
+		query.FromBlock = new(big.Int).SetUint64(start)
+		query.ToBlock = new(big.Int).SetUint64(end)
+		logs, err := b.node().FilterLogs(b.ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, vLog := range logs {
+			if err := b.storeLog(vLog); err != nil {
+				return err
+			}
+		}
+		if err := b.MongoDB.SaveCursor(model.CursorBscLogs, end); err != nil {
+			return err
 		}
 	}
+	log.Println("BSC: backfill finished at block", head)
+	return nil
 }
 
 func (b *BSCConnection) StoreTransactions() {
+	if err := b.Backfill(); err != nil {
+		log.Println(err)
+	}
+	ticker := time.NewTicker(util.EndpointProbeSeconds * time.Second)
+	defer ticker.Stop()
 	for {
 		select {
 		case err := <-b.sub.Err():
-			log.Println(err)
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				c := config.New()
-				err := b.InitConnection(b.ctx, c.SwapInfo.Bsc, b.MongoDB, b.konConn, b.msgChan)
-				if err != nil {
-					log.Println(err)
-					// TODO: what do we do if reconnection is failed?
-				}
+			log.Println("BSC: subscription error:", err)
+			b.subscription.Failed(err)
+			b.reconnect()
+		case <-ticker.C:
+			changed, err := b.endpoints.Probe(b.ctx)
+			if err != nil {
+				log.Println(err)
+			} else if changed {
+				b.reconnect()
 			}
 		case vLog := <-b.logChan:
-			amountBscTransaction := b.getAmount(vLog.Data)
-			log.Println("###### Get source bsc transaction data:", vLog, ", amount:", amountBscTransaction, "######")
-			if strings.ToLower("0x"+vLog.Topics[2].String()[26:]) != strings.ToLower(crypto.PubkeyToAddress(*b.pubKey).String()) {
-				log.Println("Not swap transaction")
-				continue
-			}
-			_, err := b.MongoDB.InsertBscTx(&vLog)
-			if err != nil {
+			b.subscription.Event()
+			if err := b.storeLog(vLog); err != nil {
 				log.Println(err)
 			}
 		}
 	}
 }
 
+// storeLog keeps a deposit to the swap address and moves the block cursor
+func (b *BSCConnection) storeLog(vLog types.Log) error {
+	if !isTransferLog(&vLog) {
+		log.Println("BSC: skipping log", vLog.TxHash.String(), vLog.Index, "that is not a Transfer")
+		return nil
+	}
+	amountBscTransaction := b.getAmount(vLog.Data)
+	log.Println("###### Get source bsc transaction data:", vLog, ", amount:", amountBscTransaction, "######")
+	from := common.BytesToAddress(vLog.Topics[1].Bytes())
+	to := common.BytesToAddress(vLog.Topics[2].Bytes())
+	if to != crypto.PubkeyToAddress(*b.pubKey) && !b.isDepositAddress(to) {
+		log.Println("Not swap transaction")
+		return nil
+	}
+	if b.isDepositAddress(from) { // sweep of a deposit address
+		return b.MongoDB.SaveCursor(model.CursorBscLogs, vLog.BlockNumber)
+	}
+	if vLog.Removed {
+		return b.cancelDeposit(&vLog)
+	}
+	if _, err := b.MongoDB.InsertBscTx(&vLog); err != nil {
+		return err
+	}
+	return b.MongoDB.SaveCursor(model.CursorBscLogs, vLog.BlockNumber)
+}
+
+// cancelDeposit drops a deposit removed by a chain reorg and cancels the swap waiting for it
+func (b *BSCConnection) cancelDeposit(vLog *types.Log) error {
+	log.Println("BSC: deposit", vLog.TxHash.String(), "is removed by a chain reorg")
+	if _, err := b.MongoDB.UpdateBscTx(vLog); err != nil {
+		return err
+	}
+	filter := map[string]string{
+		"source_network":      "bsc",
+		"source_network_hash": vLog.TxHash.String(),
+	}
+	result, err := b.MongoDB.FindTx(filter)
+	if err == mongodrv.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tx, _ := result.(model.Tx)
+	if tx.Status != model.StatusSourceSeen {
+		log.Printf("BSC: reorged deposit of swap %s is already in status %s\n", tx.ID.Hex(), tx.Status)
+		return nil
+	}
+	return updateTxStatus(b.MongoDB, &tx, model.StatusFailed, "deposit "+tx.SourceNetworkHash+" removed by chain reorg")
+}
+
 func (b *BSCConnection) HandleMessage() {
+	if err := b.ResumeSwaps(); err != nil {
+		log.Println(err)
+	}
 	for {
-		time.Sleep(1 * time.Minute)
-		if b.q.Len() > 0 {
-			front := b.q.PopFront()
-			target, _ := front.(*model.Tx)
-			log.Printf("*********** queue front target: %+v\n", target)
-			has_match := false
-			filter := map[string]string{
-				"removed": "false",
-			}
-			cur, err := b.MongoDB.FindBscTx(filter) // this case cannot update transaction complete case
-			if err != nil {
+		job, err := b.MongoDB.LeaseJob(model.QueueBscSwap, util.JobLeaseMinutes*time.Minute)
+		if err != nil {
+			if err != mongodrv.ErrNoDocuments {
 				log.Println(err)
-				continue
-			}
-			defer cur.Close(b.MongoDB.Ctx)
-			for cur.Next(b.MongoDB.Ctx) {
-				result := types.Log{}
-				err := cur.Decode(&result)
-				if err != nil {
-					log.Println(err)
-					break
-				}
-				log.Printf("*********** bsc tx status: %+v\n", result)
-				amountBscTransaction := b.getAmount(result.Data)
-				targetAmount := decimal.NewFromFloat(target.Amount)
-				log.Println("********** amountBscTransaction", amountBscTransaction, "targetAmount", targetAmount, "equal:", targetAmount.Equal(amountBscTransaction))
-				if strings.ToLower(target.FromAddress) == strings.ToLower("0x"+result.Topics[1].String()[26:]) && targetAmount.Equal(amountBscTransaction) {
-					isBlackList, err := b.processTransaction(target, &result)
-					if err == nil || isBlackList {
-						result.Removed = true
-						_, err := b.MongoDB.UpdateBscTx(&result) // this case cannot update transaction complete case
-						if err != nil {
-							log.Println(err)
-							break
-						}
-						has_match = true
-					}
-				} else {
-					continue
-				}
 			}
-			target.TxTryCount++
-			if target.TxTryCount == 20 {
-				log.Printf("*********** Transaction %+v timeout!", target)
-				amount := decimal.NewFromFloat(target.Amount)
-				filter = map[string]string{
-					"from_address":                  target.FromAddress,
-					"to_address":                    target.ToAddress,
-					"source_network":                "bsc",
-					"source_network_completed":      "false",
-					"destination_network_completed": "false",
-					"destination_network":           "knstl",
-					"created_at":                    target.CreatedAt,
-					"amount":                        amount.String(),
-				}
-				result, _ := b.MongoDB.FindTx(filter)
-				tx, _ := result.(model.Tx)
-				tx.Completed = true
-				_, _ = b.MongoDB.UpdateTx(&tx)
+			time.Sleep(util.JobPollSeconds * time.Second)
+			continue
+		}
+		b.handleJob(job)
+	}
+}
+
+func (b *BSCConnection) handleJob(job *model.Job) {
+	result, err := b.MongoDB.GetTx(job.TxID)
+	if err != nil {
+		log.Println(err)
+		retryJob(b.MongoDB, job, err)
+		return
+	}
+	target, _ := result.(model.Tx)
+	log.Printf("*********** queue front target: %+v\n", target)
+	if target.Completed || target.SourceNetworkCompleted { // the payout runs on QueueKnstlPayout
+		finishJob(b.MongoDB, job, model.JobStatusDone)
+		return
+	}
+
+	hasMatch, err := b.matchDeposit(&target)
+	if err == errNotFinal { // waiting for confirmations does not count as an attempt
+		retryJob(b.MongoDB, job, nil)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		retryJob(b.MongoDB, job, err)
+		return
+	}
+	if hasMatch {
+		finishJob(b.MongoDB, job, model.JobStatusDone)
+		return
+	}
+
+	if !target.Status.CanTransitionTo(model.StatusExpired) { // its deposit is seen, retried until it is processed
+		log.Println("Bsc swap", target.ID.Hex(), "waits for its", target.Status.String(), "deposit", target.SourceNetworkHash)
+		retryJob(b.MongoDB, job, nil)
+		return
+	}
+	job.Attempts++
+	if job.Attempts >= job.MaxAttempts {
+		log.Printf("*********** Transaction %+v timeout!", target)
+		if err := updateTxStatus(b.MongoDB, &target, model.StatusExpired, fmt.Sprintf("no matching deposit after %d attempts", job.Attempts)); err != nil {
+			retryJob(b.MongoDB, job, err)
+			return
+		}
+		finishJob(b.MongoDB, job, model.JobStatusDead)
+		return
+	}
+	log.Printf("*********** Unfinished tx: %+v\n", target)
+	retryJob(b.MongoDB, job, nil)
+}
+
+// matchDeposit looks for a stored bsc deposit matching the swap request and processes it
+func (b *BSCConnection) matchDeposit(target *model.Tx) (bool, error) {
+	head, err := b.node().BlockNumber(b.ctx)
+	if err != nil {
+		return false, err
+	}
+	filter := map[string]string{
+		"removed": "false",
+	}
+	cur, err := b.MongoDB.FindBscTx(filter) // this case cannot update transaction complete case
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close(b.MongoDB.Ctx)
+	for cur.Next(b.MongoDB.Ctx) {
+		result := types.Log{}
+		err := cur.Decode(&result)
+		if err != nil {
+			return false, err
+		}
+		if !isTransferLog(&result) { // stored before the logs were filtered by topic
+			continue
+		}
+		log.Printf("*********** bsc tx status: %+v\n", result)
+		amountBscTransaction := b.getAmount(result.Data)
+		targetAmount, err := decimal.NewFromString(target.Amount)
+		if err != nil {
+			return false, err
+		}
+		log.Println("********** amountBscTransaction", amountBscTransaction, "targetAmount", targetAmount, "equal:", targetAmount.Equal(amountBscTransaction))
+		if target.DepositAddress != "" { // any sender and amount, the address belongs to the swap
+			if common.HexToAddress(target.DepositAddress) != common.BytesToAddress(result.Topics[2].Bytes()) {
+				continue
 			}
-			if !has_match && target.TxTryCount < 20 {
-				log.Printf("*********** Unfinished tx: %+v\n", target)
-				b.q.PushBack(target)
+		} else if strings.ToLower(target.FromAddress) != strings.ToLower("0x"+result.Topics[1].String()[26:]) || !targetAmount.Equal(amountBscTransaction) {
+			continue
+		}
+		final := head+1 >= result.BlockNumber+b.confirmations
+		isBlackList, err := b.processTransaction(target, &result, final)
+		if err == errNotFinal {
+			return false, err
+		}
+		if err == nil || isBlackList {
+			result.Removed = true
+			_, err := b.MongoDB.UpdateBscTx(&result) // this case cannot update transaction complete case
+			if err != nil {
+				return false, err
 			}
+			return true, nil
 		}
 	}
+	return false, cur.Err()
 }
 
-func (b *BSCConnection) processTransaction(inputData *model.Tx, vLog *types.Log) (isBlackList bool, err error) {
+func (b *BSCConnection) processTransaction(inputData *model.Tx, vLog *types.Log, final bool) (isBlackList bool, err error) {
 	log.Printf("###### Get source bsc transaction data: %+v ######\n", vLog)
 
 	from := vLog.Topics[1]
@@ -264,6 +731,12 @@ func (b *BSCConnection) processTransaction(inputData *model.Tx, vLog *types.Log)
 		"created_at":                    inputData.CreatedAt,
 		"amount":                        amountInDB.String(),
 	}
+	if inputData.DepositAddress != "" {
+		filter = map[string]string{
+			"deposit_address": inputData.DepositAddress,
+			"source_network":  "bsc",
+		}
+	}
 	log.Printf("Bsc transaction data to search in the DB: %+v\n", filter)
 	result, err := b.MongoDB.FindTx(filter) // this case cannot update transaction complete case
 	if err != nil {
@@ -276,218 +749,518 @@ func (b *BSCConnection) processTransaction(inputData *model.Tx, vLog *types.Log)
 		log.Println("The result is not transaction type")
 		return false, err
 	}
-	tx.SourceNetworkHash = vLog.TxHash.String()
-	tx.SourceNetworkCompleted = true
-	tx.UpdatedAt = time.Now().Format(util.TimeFormat)
-
-	if isblacklistAmountbigger { // Update transaction status is completed
-		tx.Completed = true
+	if tx.Status == model.StatusSourceSeen && tx.SourceNetworkHash != vLog.TxHash.String() {
+		return false, fmt.Errorf("swap %s already waits for deposit %s", tx.ID.Hex(), tx.SourceNetworkHash)
 	}
-	_, err = b.MongoDB.UpdateTx(&tx) // this case cannot update transaction complete case
-	if err != nil {
-		log.Println(err)
-		return false, err
+	if tx.Status != model.StatusSourceSeen {
+		tx.SourceNetworkHash = vLog.TxHash.String()
+		tx.SourceNetworkIndex = int(vLog.Index)
+		tx.SourceNetworkHeight = vLog.BlockNumber
+		tx.SourceSender = fromAddr.Hex()
+		if requested, _ := decimal.NewFromString(tx.Amount); tx.DepositAddress != "" && !requested.Equal(amountInDB) {
+			log.Println("Bsc swap", tx.ID.Hex(), "requested", tx.Amount, "but", amountInDB, "is deposited")
+			tx.Amount = amountInDB.String() // pay out what arrived at the deposit address
+		}
+		err = updateTxStatus(b.MongoDB, &tx, model.StatusSourceSeen, "deposit "+tx.SourceNetworkHash+" found")
+		if err != nil {
+			return false, err
+		}
 	}
 	if isblacklistAmountbigger { // Protect blacklist swap
 		log.Println("blacklist address amount request is more than 1000000 DARC. Cannot conitnue to swap")
+		_ = updateTxStatus(b.MongoDB, &tx, model.StatusRejected, "blacklisted sender above the allowed amount")
+		r := model.NewRefund("bsc", vLog.TxHash.String(), int(vLog.Index))
+		r.Sender = fromAddr.Hex()
+		r.Amount = amountInDB.String()
+		r.Fee = util.UserBscTransactionFee
+		r.Reason = "blacklisted sender above the allowed amount"
+		recordRefund(b.MongoDB, r, &tx)
 		return true, nil
 	}
+	if !final {
+		log.Println("Bsc deposit", tx.SourceNetworkHash, "waits for", b.confirmations, "confirmations")
+		return false, errNotFinal
+	}
+	err = updateTxStatus(b.MongoDB, &tx, model.StatusSourceConfirmed, "deposit "+tx.SourceNetworkHash+" confirmed")
+	if err != nil {
+		return false, err
+	}
+	// consumed before the payout, a crash during it must not leave the deposit to the orphan refunds
+	if err := b.MongoDB.ConsumeBscTx(vLog, tx.ID); err != nil {
+		log.Println(err) // the orphan refunds still skip deposits referenced by a swap
+	}
 	log.Printf("Bsc transaction data is updated in the DB: %+v\n", result)
 	log.Println("The bsc source network operation is finished. $$$$$$")
-	b.konConn.DisburseFunds(&tx)
+	enqueuePayout(b.MongoDB, model.QueueKnstlPayout, &tx)
 	return false, nil
 }
 
+// ProcessPayouts runs the knstl payouts of the confirmed bsc deposits off the bsc swap queue
+func (b *BSCConnection) ProcessPayouts() {
+	b.konConn.ResumeDisbursements()
+	processPayouts(b.MongoDB, model.QueueKnstlPayout, "bsc", b.konConn.DisburseFunds)
+}
+
 func (b *BSCConnection) getAmount(data []byte) decimal.Decimal {
 	ev, _ := b.contractAbi.Unpack("Transfer", data) // this case cannot update transaction complete case
 	amountRaw := ev[0]
 	log.Println("amount raw: ", amountRaw)
 	amountBigInt, _ := amountRaw.(*big.Int)
-	log.Println("Bsc amount float conversion: ", amountBigInt)
-	amount, _ := decimal.NewFromString(amountBigInt.String())
+	amount := util.FromBaseUnits("bsc", amountBigInt)
 	log.Println("Bsc amount decimal conversion: ", amount)
-	bscUnit, _ := decimal.NewFromString(amountBscUnit)
-	log.Println("Bsc basic unit amount decimal conversion: ", bscUnit)
-	return amount.Div(bscUnit)
+	return amount
 }
 
 func (b *BSCConnection) DisburseFunds(t *model.Tx) {
 	// Reference: https://goethereumbook.org/transfer-eth/
 	log.Println("bsc token conversion start. Destination network operation. $$$$$$")
-	toAddr := t.ToAddress
+	if !b.payouts.acquire(t.ID) {
+		log.Println("Bsc payout of swap", t.ID.Hex(), "is already running")
+		return
+	}
+	defer b.payouts.release(t.ID)
+	if t.Status != model.StatusDisbursing && t.Status != model.StatusDisbursed {
+		if err := updateTxStatus(b.MongoDB, t, model.StatusDisbursing, "disbursing bsc funds"); err != nil {
+			return
+		}
+	}
+	d, err := openDisbursement(b.MongoDB, t)
+	if err != nil { // a payout recorded before may be on chain, retried by WatchDisbursements
+		log.Println("Failed to open disbursement of swap", t.ID.Hex(), ":", err)
+		return
+	}
 
-	fromAddress := crypto.PubkeyToAddress(*b.pubKey)
-	log.Println("Bsc From Address(BSC_CORPORATE_ADDR):", fromAddress)
-	nonce, err := b.client.PendingNonceAt(b.ctx, fromAddress)
+	var signedTx *types.Transaction
+	rebroadcast := d.RawTx != ""
+	if rebroadcast { // signed before a restart, never build a second payout
+		log.Println("Re-broadcasting signed bsc tx", d.Hash, "of swap", t.ID.Hex())
+		signedTx, err = decodeRawTx(d.RawTx)
+		if err != nil { // the recorded tx may be mined, never fail a payout that can still land
+			log.Println("Failed to decode signed bsc tx", d.Hash, "of swap", t.ID.Hex(), ":", err)
+			return
+		}
+	} else {
+		signedTx, err = b.signTransfer(t)
+		if err != nil {
+			log.Println(err)
+			b.failTransaction(t, err.Error())
+			return
+		}
+		raw, err := signedTx.MarshalBinary()
+		if err != nil {
+			b.nonces.Release(signedTx.Nonce(), false)
+			b.failTransaction(t, "failed to encode signed tx: "+err.Error())
+			return
+		}
+		d.RawTx = hexutil.Encode(raw)
+		d.Hash = signedTx.Hash().Hex()
+		d.Hashes = []string{d.Hash}
+		d.Status = model.DisbursementSigned
+		if _, err := b.MongoDB.UpdateDisbursement(d); err != nil {
+			log.Println(err)
+			b.nonces.Release(signedTx.Nonce(), false)
+			b.failTransaction(t, "failed to record signed tx: "+err.Error())
+			return
+		}
+	}
+	log.Printf("Signed tx: %+v\n", signedTx.Hash().Hex())
+
+	err = b.node().SendTransaction(b.ctx, signedTx)
+	if !rebroadcast { // a failed send may still reach a mempool, the nonce stays taken
+		b.nonces.Release(signedTx.Nonce(), true)
+	}
 	if err != nil {
+		log.Println("Failed to send transaction: ", err)
+		taken, checkErr := b.nonceTaken(signedTx.Nonce(), disbursementHashes(d))
+		switch {
+		case checkErr != nil:
+			log.Println(checkErr)
+			return
+		case taken:
+			b.failTransaction(t, fmt.Sprintf("nonce %d is used by another tx: %v", signedTx.Nonce(), err))
+			return
+		case !isKnownBscTx(err): // picked up again by WatchDisbursements
+			log.Println("Bsc payout", d.Hash, "of swap", t.ID.Hex(), "is kept for rebroadcast")
+			return
+		}
+	}
+	d.Status = model.DisbursementBroadcast
+	if _, err := b.MongoDB.UpdateDisbursement(d); err != nil {
 		log.Println(err)
-		b.updateTransactionComplete(t)
+	}
+
+	// Confirm that bsc transaction is done
+	t.DestinationNetworkHash = signedTx.Hash().Hex()
+	if t.Status == model.StatusDisbursing {
+		_ = updateTxStatus(b.MongoDB, t, model.StatusDisbursed, "broadcast "+t.DestinationNetworkHash)
+	}
+	log.Println("Start bsc transaction confirmation check")
+	var receipt *types.Receipt
+	deadline := time.Now().Add(b.confirmTimeout)
+	for {
+		stuckAt := time.Now().Add(b.stuckAfter)
+		receipt, err = b.waitForReceipt(disbursementHashes(d), stuckAt, deadline)
+		if err != errNotMined {
+			break
+		}
+		log.Println("Bsc transaction", signedTx.Hash().Hex(), "is not mined after", b.stuckAfter)
+		replacement, replaceErr := b.replaceTransaction(t, d, signedTx)
+		if replaceErr != nil { // keep waiting on the fee levels already broadcast
+			log.Println("Failed to replace stuck bsc transaction:", replaceErr)
+			continue
+		}
+		signedTx = replacement
+	}
+	if err == errConfirmTimeout { // left in disbursed, picked up again by WatchDisbursements
+		log.Println("Bsc transaction", signedTx.Hash().Hex(), "is not confirmed before the deadline")
 		return
 	}
-	log.Println("Nonce:", nonce)
-	value := big.NewInt(0)
-	gasPrice, err := b.client.SuggestGasPrice(b.ctx)
 	if err != nil {
-		log.Println(err)
-		b.updateTransactionComplete(t)
+		log.Println("Bsc transaction sent has error: ", err)
+		b.failTransaction(t, "bsc transaction "+err.Error())
 		return
 	}
-	log.Println("Gas price:", gasPrice)
-	toAddrChecked := common.HexToAddress(toAddr)
-	log.Println("ToAddress:", toAddr, "toAddrHexToAddress", toAddrChecked)
-	gasLimit := uint64(76708)
+	log.Println("Finished bsc transaction confirmation check in block", receipt.BlockNumber)
 
-	transferFnSignature := []byte("transfer(address,uint256)")
-	hash := sha3.NewLegacyKeccak256()
-	hash.Write(transferFnSignature)
-	methodID := hash.Sum(nil)[:4]
-	log.Println("Method id:", hexutil.Encode(methodID))
+	log.Println("Complete bsc transaction:", receipt.TxHash.Hex())
+	t.DestinationNetworkHash = receipt.TxHash.Hex() // an earlier fee level may have been mined
+	d.Status = model.DisbursementConfirmed
+	if _, err := b.MongoDB.UpdateDisbursement(d); err != nil {
+		log.Println(err)
+	}
+	_ = updateTxStatus(b.MongoDB, t, model.StatusConfirmed, "confirmed "+t.DestinationNetworkHash)
+	log.Println("Destination network hash:", t.DestinationNetworkHash, "Destination network completed", t.DestinationNetworkCompleted)
+	log.Printf("transaction is updated in the DB: %+v\n", *t)
 
-	paddedToAddress := common.LeftPadBytes(toAddrChecked.Bytes(), 32)
-	log.Println("PaddedToAddress:", hexutil.Encode(paddedToAddress))
+	b.msgChan <- "****** Disbursing bsc funds for transaction: " + t.ID.String() + " ******"
+}
+
+// signTransfer builds and signs the token transfer paying out the swap
+func (b *BSCConnection) signTransfer(t *model.Tx) (*types.Transaction, error) {
+	fromAddress := crypto.PubkeyToAddress(*b.pubKey)
+	log.Println("Bsc From Address(BSC_CORPORATE_ADDR):", fromAddress)
+	toAddr := common.HexToAddress(t.ToAddress)
+	log.Println("ToAddress:", t.ToAddress, "toAddrHexToAddress", toAddr)
 
-	decimalAmount := util.GetTransactionAmount("bsc", t.Amount)
+	txAmount, err := decimal.NewFromString(t.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q: %v", t.Amount, err)
+	}
+	decimalAmount := util.GetTransactionAmount("bsc", txAmount)
 	log.Println("user transaction total bsc amount:", decimalAmount)
-	decimalBscFee := util.GetTransactionAmount("bsc", util.UserBscTransactionFee)
+	decimalBscFee := util.GetTransactionAmount("bsc", decimal.RequireFromString(util.UserBscTransactionFee))
 	log.Println("user transaction fee bsc amount:", decimalBscFee)
 	totalAmountDecimal := decimalAmount.Sub(decimalBscFee)
 	log.Println("Total decimal amount deducting fee:", totalAmountDecimal)
+	return b.signTokenTransfer(toAddr, totalAmountDecimal.BigInt())
+}
 
-	amount := new(big.Int)
-	amount.SetString(totalAmountDecimal.String(), 10)
+// signTokenTransfer signs a token transfer of amount base units from BSC_CORPORATE_ADDR
+// with the next corporate nonce. The caller releases the nonce.
+func (b *BSCConnection) signTokenTransfer(toAddr common.Address, amount *big.Int) (*types.Transaction, error) {
+	fromAddress := crypto.PubkeyToAddress(*b.pubKey)
 	log.Println("Total amount str:", amount.String())
-	paddedAmount := common.LeftPadBytes(amount.Bytes(), 32)
-	log.Println("PaddedAmount:", hexutil.Encode(paddedAmount))
-	var data []byte
-	data = append(data, methodID...)
-	data = append(data, paddedToAddress...)
-	data = append(data, paddedAmount...)
 
-	tx := types.NewTransaction(nonce, b.contractAddress, value, gasLimit, gasPrice, data)
-	log.Printf("Transaction: %+v\n", tx)
-	chainID, err := b.client.NetworkID(context.Background())
+	gasLimit, err := b.estimateTransferGas(fromAddress, toAddr, amount)
 	if err != nil {
-		log.Println(err)
-		b.updateTransactionComplete(t)
-		return
+		return nil, fmt.Errorf("failed to estimate gas: %v", err)
 	}
-	log.Printf("Chain id: %+v\n", chainID)
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), b.privKey)
+	log.Println("Gas limit:", gasLimit)
+
+	opts, err := bind.NewKeyedTransactorWithChainID(b.privKey, b.chainID)
 	if err != nil {
-		log.Println("Error creating and signing transaction: ", err)
-		b.updateTransactionComplete(t)
-		return
+		return nil, fmt.Errorf("failed to create transactor: %v", err)
 	}
-	log.Printf("Signed tx: %+v\n", signedTx.Hash().Hex())
-
-	// Sometimes nonce calculation is not accurate and it can get old hash.
-	// To making transaction in this case, check the hash and stop proceeding.
-	filter := map[string]string{
-		"from_address":                  t.FromAddress,
-		"source_network_completed":      "true",
-		"destination_network_hash":      signedTx.Hash().Hex(),
-		"destination_network_completed": "true",
+	opts.Context = b.ctx
+	opts.GasLimit = gasLimit
+	if err := b.setFees(opts); err != nil {
+		return nil, err
 	}
-	row, err := b.MongoDB.FindTx(filter)
+	nonce, err := b.nonces.Allocate()
 	if err != nil {
-		if err != mongodrv.ErrNoDocuments {
-			log.Println(err)
-			b.updateTransactionComplete(t)
-			return
-		}
+		return nil, fmt.Errorf("failed to get nonce: %v", err)
 	}
-	if row != nil {
-		log.Println("Failed to send transaction: tx is signed with old nonce. This means that tx is considered as old tx existed.")
-		b.updateTransactionComplete(t)
-		return
+	log.Println("Nonce:", nonce)
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+	opts.NoSend = true // broadcast after the signed tx is recorded
+	token, err := BEP20Token.NewBEP20TokenTransactor(b.contractAddress, b.node())
+	if err != nil {
+		b.nonces.Release(nonce, false)
+		return nil, err
 	}
-
-	// if new signed transaction with new nonce, proceed transaction.
-	err = b.client.SendTransaction(b.ctx, signedTx)
+	signedTx, err := token.Transfer(opts, toAddr, amount)
 	if err != nil {
-		log.Println("Failed to send transaction: ", err)
-		b.updateTransactionComplete(t)
-		return
+		b.nonces.Release(nonce, false)
+		return nil, fmt.Errorf("failed to sign: %v", err)
 	}
+	log.Printf("Transaction: %+v\n", signedTx)
+	return signedTx, nil
+}
 
-	// Confirm that bsc transaction is done
-	t.DestinationNetworkHash = signedTx.Hash().Hex()
-	status := "0"
-	transactionCheckTryCount := 0
-	log.Println("Start bsc transaction confirmation check")
-	for status != "0" {
-		// https://docs.bscscan.com/support/common-error-messages
-		// api rate has limit. Thus, put 30 seconds term
-		time.Sleep(util.SleepTimeSeconds * time.Second)
-		status, err = b.getTransactionStatus(signedTx.Hash().Hex())
+// setFees prices the payout as a legacy or a dynamic fee tx depending on the config
+func (b *BSCConnection) setFees(opts *bind.TransactOpts) error {
+	if b.txType != txTypeDynamic {
+		gasPrice, err := b.node().SuggestGasPrice(b.ctx)
 		if err != nil {
-			log.Println("Bsc transaction sent has error: ", err)
-			b.updateTransactionComplete(t)
-			return
+			return fmt.Errorf("failed to get gas price: %v", err)
 		}
-		transactionCheckTryCount++
+		log.Println("Gas price:", gasPrice)
+		opts.GasPrice = gasPrice
+		return nil
 	}
-	log.Println("Bsc transaction confirmation check total try: ", transactionCheckTryCount)
-	log.Println("Finished bsc transaction confirmation check")
 
-	log.Println("Complete bsc transaction:", signedTx.Hash().Hex())
-	t.DestinationNetworkCompleted = true
-	log.Println("Destination network hash:", t.DestinationNetworkHash, "Destination network completed", t.DestinationNetworkCompleted)
-	b.updateTransactionComplete(t)
-	log.Printf("transaction is updated in the DB: %+v\n", *t)
+	head, err := b.node().HeaderByNumber(b.ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get head: %v", err)
+	}
+	if head.BaseFee == nil {
+		return fmt.Errorf("bsc node does not support dynamic fee txs")
+	}
+	tip, err := b.node().SuggestGasTipCap(b.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get gas tip cap: %v", err)
+	}
+	if b.gasTipCap != nil && tip.Cmp(b.gasTipCap) > 0 {
+		tip = new(big.Int).Set(b.gasTipCap)
+	}
+	feeCap := new(big.Int).Add(new(big.Int).Mul(head.BaseFee, big.NewInt(2)), tip)
+	if b.gasFeeCap != nil && feeCap.Cmp(b.gasFeeCap) > 0 {
+		feeCap = new(big.Int).Set(b.gasFeeCap)
+	}
+	if feeCap.Cmp(tip) < 0 {
+		return fmt.Errorf("gas fee cap %s is below the tip %s", feeCap, tip)
+	}
+	log.Println("Gas fee cap:", feeCap, "gas tip cap:", tip)
+	opts.GasFeeCap = feeCap
+	opts.GasTipCap = tip
+	return nil
+}
 
-	b.msgChan <- "****** Disbursing bsc funds for transaction: " + t.ID.String() + " ******"
+// estimateTransferGas estimates the token transfer and adds the configured safety margin
+func (b *BSCConnection) estimateTransferGas(from, to common.Address, amount *big.Int) (uint64, error) {
+	data, err := b.contractAbi.Pack("transfer", to, amount)
+	if err != nil {
+		return 0, err
+	}
+	gas, err := b.node().EstimateGas(b.ctx, ethereum.CallMsg{
+		From: from,
+		To:   &b.contractAddress,
+		Data: data,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return gas * (100 + b.gasMarginPercent) / 100, nil
 }
 
-func (b *BSCConnection) GetTx(hash string) (map[string]interface{}, error) {
-	txHash := common.HexToHash(hash)
-	bscTransactionCheckUrl := fmt.Sprintf(b.transactionScanApiUrl, txHash.String())
-	log.Println("BscTxUrl:", bscTransactionCheckUrl)
-	tx, err := http.Get(bscTransactionCheckUrl)
+// ResumeDisbursements picks up the bsc payouts interrupted by a restart
+func (b *BSCConnection) ResumeDisbursements() {
+	resumeDisbursements(b.MongoDB, "bsc", b.DisburseFunds)
+}
+
+// WatchDisbursements re-runs the open bsc payouts every DisbursementRecheckMinutes
+func (b *BSCConnection) WatchDisbursements() {
+	watchDisbursements(b.MongoDB, "bsc", b.DisburseFunds)
+}
+
+// decodeRawTx decodes a recorded signed tx
+func decodeRawTx(rawTx string) (*types.Transaction, error) {
+	raw, err := hexutil.Decode(rawTx)
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
-	var txResult map[string]interface{}
-	err = json.NewDecoder(tx.Body).Decode(&txResult)
-	if err != nil {
-		log.Println(err)
+	signedTx := new(types.Transaction)
+	if err := signedTx.UnmarshalBinary(raw); err != nil {
 		return nil, err
 	}
-	defer tx.Body.Close()
-	return txResult, nil
+	return signedTx, nil
 }
 
-func (b *BSCConnection) updateTransactionComplete(tx *model.Tx) {
-	tx.Completed = true
-	tx.UpdatedAt = time.Now().Format(util.TimeFormat)
-	_, err := b.MongoDB.UpdateTx(tx)
+// nonceTaken reports whether the corporate nonce is mined by a tx other than the given ones,
+// none of them can be mined anymore then
+func (b *BSCConnection) nonceTaken(nonce uint64, hashes []common.Hash) (bool, error) {
+	mined, err := b.node().NonceAt(b.ctx, b.corporateAddress, nil)
 	if err != nil {
+		return false, err
+	}
+	if mined <= nonce {
+		return false, nil
+	}
+	for _, hash := range hashes {
+		_, err := b.node().TransactionReceipt(b.ctx, hash)
+		if err == nil {
+			return false, nil
+		}
+		if err != ethereum.NotFound {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// isKnownBscTx reports whether a re-broadcast failed only because the node already has the tx
+func isKnownBscTx(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "already known") ||
+		strings.Contains(msg, "known transaction") ||
+		strings.Contains(msg, "nonce too low")
+}
+
+// waitForReceipt polls the node until one of the payout's fee levels is mined and buried under
+// the confirmation depth. Returns errNotMined when none is mined by stuckAt and
+// errConfirmTimeout when the deadline passes first.
+func (b *BSCConnection) waitForReceipt(hashes []common.Hash, stuckAt, deadline time.Time) (*types.Receipt, error) {
+	for {
+		var receipt *types.Receipt
+		for _, hash := range hashes {
+			r, err := b.node().TransactionReceipt(b.ctx, hash)
+			if err == nil {
+				receipt = r
+				break
+			}
+			if err != ethereum.NotFound {
+				log.Println(err)
+			}
+		}
+		if receipt != nil {
+			if receipt.Status != types.ReceiptStatusSuccessful {
+				return receipt, fmt.Errorf("reverted in block %s", receipt.BlockNumber)
+			}
+			head, err := b.node().BlockNumber(b.ctx)
+			if err != nil {
+				log.Println(err)
+			} else if head+1 >= receipt.BlockNumber.Uint64()+b.confirmations {
+				return receipt, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, errConfirmTimeout
+		}
+		if receipt == nil && time.Now().After(stuckAt) {
+			return nil, errNotMined
+		}
+		time.Sleep(util.SleepTimeSeconds * time.Second)
+	}
+}
+
+// replaceTransaction re-signs a stuck payout with the same nonce and bumped fees,
+// records the replacement on the ledger and the swap, then broadcasts it
+func (b *BSCConnection) replaceTransaction(t *model.Tx, d *model.Disbursement, stuck *types.Transaction) (*types.Transaction, error) {
+	var inner types.TxData
+	if stuck.Type() == types.DynamicFeeTxType {
+		feeCap := bumpFee(stuck.GasFeeCap(), b.gasBumpPercent, b.gasPriceCeiling)
+		if feeCap.Cmp(stuck.GasFeeCap()) <= 0 {
+			return nil, fmt.Errorf("gas fee cap %s is at the ceiling", stuck.GasFeeCap())
+		}
+		tipCap := bumpFee(stuck.GasTipCap(), b.gasBumpPercent, feeCap)
+		log.Println("Replacing bsc transaction", stuck.Hash().Hex(), "with gas fee cap", feeCap, "and gas tip cap", tipCap)
+		inner = &types.DynamicFeeTx{
+			ChainID:   b.chainID,
+			Nonce:     stuck.Nonce(),
+			GasTipCap: tipCap,
+			GasFeeCap: feeCap,
+			Gas:       stuck.Gas(),
+			To:        stuck.To(),
+			Value:     stuck.Value(),
+			Data:      stuck.Data(),
+		}
+	} else {
+		gasPrice := bumpFee(stuck.GasPrice(), b.gasBumpPercent, b.gasPriceCeiling)
+		if gasPrice.Cmp(stuck.GasPrice()) <= 0 {
+			return nil, fmt.Errorf("gas price %s is at the ceiling", stuck.GasPrice())
+		}
+		log.Println("Replacing bsc transaction", stuck.Hash().Hex(), "with gas price", gasPrice)
+		inner = &types.LegacyTx{
+			Nonce:    stuck.Nonce(),
+			GasPrice: gasPrice,
+			Gas:      stuck.Gas(),
+			To:       stuck.To(),
+			Value:    stuck.Value(),
+			Data:     stuck.Data(),
+		}
+	}
+	replacement, err := types.SignNewTx(b.privKey, b.signer, inner)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := replacement.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	// record before broadcasting so a restart re-broadcasts the latest fee level
+	prevRawTx, prevHash, prevHashes := d.RawTx, d.Hash, disbursementHashStrings(d)
+	d.RawTx = hexutil.Encode(raw)
+	d.Hash = replacement.Hash().Hex()
+	d.Hashes = append(prevHashes, d.Hash)
+	if _, err := b.MongoDB.UpdateDisbursement(d); err != nil {
+		d.RawTx, d.Hash, d.Hashes = prevRawTx, prevHash, prevHashes
+		return nil, err
+	}
+
+	if err := b.node().SendTransaction(b.ctx, replacement); err != nil {
+		d.RawTx, d.Hash, d.Hashes = prevRawTx, prevHash, prevHashes
+		if _, err := b.MongoDB.UpdateDisbursement(d); err != nil {
+			log.Println(err)
+		}
+		return nil, err
+	}
+	t.DestinationNetworkHash = d.Hash
+	t.ReplacementHashes = append(t.ReplacementHashes, d.Hash)
+	t.UpdatedAt = time.Now().Format(util.TimeFormat)
+	if _, err := b.MongoDB.UpdateTx(t); err != nil {
 		log.Println(err)
 	}
+	return replacement, nil
+}
+
+// bumpFee raises fee by percent, rounded up to a tenth of a percent, capped at ceiling when set
+func bumpFee(fee *big.Int, percent float64, ceiling *big.Int) *big.Int {
+	perMille := uint64(math.Ceil(percent * 10))
+	bumped := new(big.Int).Mul(fee, new(big.Int).SetUint64(1000+perMille))
+	bumped.Add(bumped, big.NewInt(999)) // round up so the bump is never below percent
+	bumped.Div(bumped, big.NewInt(1000))
+	if ceiling != nil && bumped.Cmp(ceiling) > 0 {
+		bumped = new(big.Int).Set(ceiling)
+	}
+	return bumped
+}
+
+// disbursementHashStrings lists every broadcast fee level of the payout,
+// ledgers written before replacements only carry the single hash
+func disbursementHashStrings(d *model.Disbursement) []string {
+	if len(d.Hashes) == 0 && d.Hash != "" {
+		return []string{d.Hash}
+	}
+	return d.Hashes
+}
+
+// disbursementHashes is disbursementHashStrings as tx hashes
+func disbursementHashes(d *model.Disbursement) []common.Hash {
+	var hashes []common.Hash
+	for _, hash := range disbursementHashStrings(d) {
+		hashes = append(hashes, common.HexToHash(hash))
+	}
+	return hashes
+}
+
+func (b *BSCConnection) failTransaction(tx *model.Tx, reason string) {
+	if err := updateTxStatus(b.MongoDB, tx, model.StatusFailed, reason); err != nil {
+		return
+	}
+	refundFailedSwap(b.MongoDB, tx, reason)
 }
 
 func (b *BSCConnection) IsTransactionSuccessful(hash string) (bool, error) {
-	status, err := b.getTransactionStatus(hash)
+	receipt, err := b.node().TransactionReceipt(b.ctx, common.HexToHash(hash))
 	if err != nil {
 		log.Println(err)
 		return false, err
 	}
-	log.Println("Bsc transaction status:", status)
-	if status == "0" {
+	log.Println("Bsc transaction status:", receipt.Status)
+	if receipt.Status != types.ReceiptStatusSuccessful {
 		err := fmt.Errorf("bsc transaction is failed")
 		return false, err
 	}
 	return true, nil
 }
-
-func (b *BSCConnection) getTransactionStatus(hash string) (string, error) {
-	bscResult, err := b.GetTx(hash)
-	if err != nil {
-		err := fmt.Errorf("bsc transaction " + err.Error())
-		return "", err
-	}
-	result, _ := bscResult["result"].(map[string]interface{})
-	status, _ := result["status"].(string)
-	return status, nil
-}
@@ -0,0 +1,317 @@
+package chain
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/crypto/hd"
+	bip39 "github.com/cosmos/go-bip39"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	keyring "github.com/konstellation/swap/internal/key"
+	"github.com/konstellation/swap/internal/model"
+	BEP20Token "github.com/konstellation/swap/internal/types"
+	"github.com/konstellation/swap/internal/util"
+)
+
+// bscDepositPath is the BIP44 path of the deposit addresses, the last element is the index
+const bscDepositPath = "m/44'/60'/0'/0/%d"
+
+// nativeTransferGas is the gas of a plain BNB transfer
+const nativeTransferGas = 21000
+
+// depositWallet derives the per-swap deposit addresses from the deposit mnemonic
+// and keeps the set of derived addresses watched by the log listener
+type depositWallet struct {
+	mu        sync.RWMutex
+	master    [32]byte
+	chainCode [32]byte
+	addresses map[common.Address]uint32
+}
+
+func newDepositWallet(mnemonic string) (*depositWallet, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid deposit mnemonic: %v", err)
+	}
+	master, chainCode := hd.ComputeMastersFromSeed(seed)
+	return &depositWallet{
+		master:    master,
+		chainCode: chainCode,
+		addresses: make(map[common.Address]uint32),
+	}, nil
+}
+
+// derive returns the key and the address of the index
+func (w *depositWallet) derive(index uint32) (*ecdsa.PrivateKey, common.Address, error) {
+	keyBytes, err := hd.DerivePrivateKeyForPath(w.master, w.chainCode, fmt.Sprintf(bscDepositPath, index))
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	key, err := crypto.ToECDSA(keyBytes)
+	if err != nil {
+		return nil, common.Address{}, err
+	}
+	return key, crypto.PubkeyToAddress(key.PublicKey), nil
+}
+
+func (w *depositWallet) watch(address common.Address, index uint32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.addresses[address] = index
+}
+
+func (w *depositWallet) has(address common.Address) bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	_, ok := w.addresses[address]
+	return ok
+}
+
+// loadDepositWallet sets up the deposit wallet when BSC_DEPOSIT_MNEMONIC_FILE is set
+// and watches every address derived so far
+func (b *BSCConnection) loadDepositWallet(mnemonicFile string) error {
+	if mnemonicFile == "" {
+		log.Println("BSC: no deposit mnemonic, deposits are matched by sender and amount")
+		return nil
+	}
+	mnemonic, err := keyring.ReadPassphrase(0, mnemonicFile)
+	if err != nil {
+		return fmt.Errorf("deposit mnemonic: %v", err)
+	}
+	wallet, err := newDepositWallet(strings.TrimSpace(mnemonic))
+	if err != nil {
+		return err
+	}
+	addresses, err := b.MongoDB.FindDepositAddresses(false)
+	if err != nil {
+		return err
+	}
+	for _, d := range addresses {
+		wallet.watch(common.HexToAddress(d.ID), d.Index)
+	}
+	log.Println("BSC: watching", len(addresses), "deposit addresses")
+	b.deposits = wallet
+	return nil
+}
+
+// isDepositAddress reports whether the address is a derived deposit address
+func (b *BSCConnection) isDepositAddress(address common.Address) bool {
+	return b.deposits != nil && b.deposits.has(address)
+}
+
+// AssignDepositAddress derives the next deposit address for a bsc->knstl swap.
+// Does nothing when no deposit mnemonic is configured.
+func (b *BSCConnection) AssignDepositAddress(tx *model.Tx) error {
+	if b.deposits == nil {
+		return nil
+	}
+	index, err := b.MongoDB.NextCounter(model.CounterDepositIndex)
+	if err != nil {
+		return err
+	}
+	_, address, err := b.deposits.derive(index)
+	if err != nil {
+		return err
+	}
+	d := &model.DepositAddress{
+		ID:    address.Hex(),
+		Index: index,
+		TxID:  tx.ID,
+	}
+	if _, err := b.MongoDB.InsertDepositAddress(d); err != nil {
+		return err
+	}
+	b.deposits.watch(address, index)
+	tx.DepositAddress = address.Hex()
+	log.Println("BSC: swap", tx.ID.Hex(), "deposits to", tx.DepositAddress)
+	return nil
+}
+
+// Sweep moves the deposits of the settled swaps into BSC_CORPORATE_ADDR every BSC_SWEEP_MINUTES.
+// Addresses stay in the sweep set until they are empty, settled and past DepositRetentionDays.
+func (b *BSCConnection) Sweep() {
+	if b.deposits == nil || b.sweepInterval <= 0 {
+		return
+	}
+	for {
+		addresses, err := b.MongoDB.FindDepositAddresses(true)
+		if err != nil {
+			log.Println(err)
+		}
+		for i := range addresses {
+			if err := b.sweep(&addresses[i]); err != nil {
+				log.Println("BSC: failed to sweep", addresses[i].ID, err)
+			}
+		}
+		time.Sleep(b.sweepInterval)
+	}
+}
+
+// sweep advances the sweep of one deposit address by a step. Deposit addresses hold no BNB,
+// so the gas is sent from BSC_CORPORATE_ADDR first and the tokens follow once it is mined.
+func (b *BSCConnection) sweep(d *model.DepositAddress) error {
+	result, err := b.MongoDB.GetTx(d.TxID)
+	if err != nil {
+		return err
+	}
+	swap, _ := result.(model.Tx)
+	if swap.Status == "" || swap.Status == model.StatusRequested || swap.Status == model.StatusSourceSeen {
+		return nil // the deposit is not final yet
+	}
+
+	if d.SweepHash != "" {
+		receipt, err := b.node().TransactionReceipt(b.ctx, common.HexToHash(d.SweepHash))
+		if err == ethereum.NotFound {
+			if _, _, err := b.node().TransactionByHash(b.ctx, common.HexToHash(d.SweepHash)); err != ethereum.NotFound {
+				return err // still pending
+			}
+			log.Println("BSC: sweep", d.SweepHash, "of", d.ID, "is dropped")
+			d.SweepHash = ""
+			_, err = b.MongoDB.UpdateDepositAddress(d)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if receipt.Status == types.ReceiptStatusSuccessful {
+			log.Println("BSC: swept", d.ID, "in", d.SweepHash)
+			d.Swept = true
+		}
+		d.SweepHash = ""
+		_, err = b.MongoDB.UpdateDepositAddress(d)
+		return err
+	}
+
+	key, address, err := b.deposits.derive(d.Index)
+	if err != nil {
+		return err
+	}
+	caller, err := BEP20Token.NewBEP20TokenCaller(b.contractAddress, b.node())
+	if err != nil {
+		return err
+	}
+	balance, err := caller.BalanceOf(&bind.CallOpts{Context: b.ctx}, address)
+	if err != nil {
+		return err
+	}
+	if balance.Sign() == 0 { // nothing to sweep, late deposits are picked up until the address is closed
+		if !swap.Status.IsTerminal() || time.Since(d.CreatedAt) < util.DepositRetentionDays*24*time.Hour {
+			return nil
+		}
+		log.Println("BSC: closing deposit address", d.ID)
+		d.Closed = true
+		_, err = b.MongoDB.UpdateDepositAddress(d)
+		return err
+	}
+
+	corporate := crypto.PubkeyToAddress(*b.pubKey)
+	gasLimit, err := b.estimateTransferGas(address, corporate, balance)
+	if err != nil {
+		return err
+	}
+	opts, err := bind.NewKeyedTransactorWithChainID(key, b.chainID)
+	if err != nil {
+		return err
+	}
+	opts.Context = b.ctx
+	opts.GasLimit = gasLimit
+	if err := b.setFees(opts); err != nil {
+		return err
+	}
+	price := opts.GasPrice
+	if price == nil {
+		price = opts.GasFeeCap
+	}
+	cost := new(big.Int).Mul(price, new(big.Int).SetUint64(gasLimit))
+	bnb, err := b.node().BalanceAt(b.ctx, address, nil)
+	if err != nil {
+		return err
+	}
+	if bnb.Cmp(cost) < 0 {
+		pending, err := b.node().PendingBalanceAt(b.ctx, address)
+		if err != nil {
+			return err
+		}
+		if pending.Cmp(bnb) > 0 { // gas already on the way
+			return nil
+		}
+		return b.fundGas(address, new(big.Int).Sub(cost, bnb), opts)
+	}
+
+	nonce, err := b.node().PendingNonceAt(b.ctx, address)
+	if err != nil {
+		return err
+	}
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+	opts.NoSend = true
+	token, err := BEP20Token.NewBEP20TokenTransactor(b.contractAddress, b.node())
+	if err != nil {
+		return err
+	}
+	signedTx, err := token.Transfer(opts, corporate, balance)
+	if err != nil {
+		return err
+	}
+	d.SweepHash = signedTx.Hash().Hex()
+	if _, err := b.MongoDB.UpdateDepositAddress(d); err != nil {
+		return err
+	}
+	log.Println("BSC: sweeping", balance, "from", d.ID, "in", d.SweepHash)
+	if err := b.node().SendTransaction(b.ctx, signedTx); err != nil {
+		d.SweepHash = ""
+		if _, err := b.MongoDB.UpdateDepositAddress(d); err != nil {
+			log.Println(err)
+		}
+		return err
+	}
+	return nil
+}
+
+// fundGas sends the BNB for the sweep gas from BSC_CORPORATE_ADDR with the fees of the sweep
+func (b *BSCConnection) fundGas(to common.Address, value *big.Int, fees *bind.TransactOpts) error {
+	nonce, err := b.nonces.Allocate()
+	if err != nil {
+		return err
+	}
+	var inner types.TxData
+	if fees.GasPrice != nil {
+		inner = &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: fees.GasPrice,
+			Gas:      nativeTransferGas,
+			To:       &to,
+			Value:    value,
+		}
+	} else {
+		inner = &types.DynamicFeeTx{
+			ChainID:   b.chainID,
+			Nonce:     nonce,
+			GasTipCap: fees.GasTipCap,
+			GasFeeCap: fees.GasFeeCap,
+			Gas:       nativeTransferGas,
+			To:        &to,
+			Value:     value,
+		}
+	}
+	signedTx, err := types.SignNewTx(b.privKey, b.signer, inner)
+	if err != nil {
+		b.nonces.Release(nonce, false)
+		return err
+	}
+	if err := b.node().SendTransaction(b.ctx, signedTx); err != nil {
+		b.nonces.Release(nonce, false)
+		return err
+	}
+	b.nonces.Release(nonce, true)
+	log.Println("BSC: sent", value, "wei of sweep gas to", to.Hex(), "in", signedTx.Hash().Hex())
+	return nil
+}
@@ -0,0 +1,145 @@
+package chain
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/konstellation/swap/internal/model"
+	"github.com/konstellation/swap/internal/mongo"
+	"github.com/konstellation/swap/internal/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+)
+
+// payoutGuard keeps two goroutines from running the payout of the same swap at once
+type payoutGuard struct {
+	mu     sync.Mutex
+	active map[primitive.ObjectID]bool
+}
+
+func newPayoutGuard() *payoutGuard {
+	return &payoutGuard{active: make(map[primitive.ObjectID]bool)}
+}
+
+// acquire reports whether the payout of the swap can run, release ends it
+func (g *payoutGuard) acquire(txID primitive.ObjectID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.active[txID] {
+		return false
+	}
+	g.active[txID] = true
+	return true
+}
+
+func (g *payoutGuard) release(txID primitive.ObjectID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.active, txID)
+}
+
+// openDisbursement returns the payout record of the swap, recording the intent on first use
+func openDisbursement(mg *mongo.Connection, tx *model.Tx) (*model.Disbursement, error) {
+	d, err := mg.GetDisbursement(tx.ID)
+	if err == nil {
+		return d, nil
+	}
+	if err != mongodrv.ErrNoDocuments {
+		return nil, err
+	}
+	d = model.NewDisbursement(tx.ID, tx.DestinationNetwork)
+	if _, err := mg.InsertDisbursement(d); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// resumeDisbursements runs the payouts to network that were interrupted before confirmation,
+// each on its own goroutine, the payout guard skips those still running. Swaps whose deposit is
+// consumed but whose payout never started are in source confirmed and are resumed too.
+func resumeDisbursements(mg *mongo.Connection, network string, disburse func(*model.Tx)) {
+	for _, status := range []model.TxStatus{model.StatusSourceConfirmed, model.StatusDisbursing, model.StatusDisbursed} {
+		filter := map[string]string{
+			"destination_network": network,
+			"status":              status.String(),
+		}
+		txs, err := mg.FindTxs(filter)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for i := range txs { // one unconfirmed payout must not hold up the others
+			log.Println("Resuming", network, "disbursement of swap", txs[i].ID.Hex())
+			go disburse(&txs[i])
+		}
+	}
+}
+
+// watchDisbursements runs the open payouts to network again every DisbursementRecheckMinutes,
+// payouts kept for rebroadcast or not confirmed before their deadline are picked up this way
+func watchDisbursements(mg *mongo.Connection, network string, disburse func(*model.Tx)) {
+	for {
+		time.Sleep(util.DisbursementRecheckMinutes * time.Minute)
+		resumeDisbursements(mg, network, disburse)
+	}
+}
+
+// enqueuePayout queues the payout of a swap whose deposit is confirmed
+func enqueuePayout(mg *mongo.Connection, queue string, tx *model.Tx) {
+	if _, err := mg.EnqueueJob(model.NewJob(queue, tx.ID, util.JobMaxAttempts)); err != nil {
+		log.Println(err) // queued again on the next start
+	}
+}
+
+// processPayouts runs the payouts queued on queue. Every payout runs on its own goroutine so
+// one waiting for its confirmation never holds up the others, a lease expiring meanwhile is
+// caught by the payout guard. Swaps of sourceNetwork confirmed before a restart are queued first.
+func processPayouts(mg *mongo.Connection, queue, sourceNetwork string, disburse func(*model.Tx)) {
+	confirmed, err := mg.FindTxs(map[string]string{
+		"source_network": sourceNetwork,
+		"status":         model.StatusSourceConfirmed.String(),
+	})
+	if err != nil {
+		log.Println(err)
+	}
+	for i := range confirmed {
+		enqueuePayout(mg, queue, &confirmed[i])
+	}
+	for {
+		job, err := mg.LeaseJob(queue, util.JobLeaseMinutes*time.Minute)
+		if err != nil {
+			if err != mongodrv.ErrNoDocuments {
+				log.Println(err)
+			}
+			time.Sleep(util.JobPollSeconds * time.Second)
+			continue
+		}
+		go handlePayoutJob(mg, job, disburse)
+	}
+}
+
+func handlePayoutJob(mg *mongo.Connection, job *model.Job, disburse func(*model.Tx)) {
+	result, err := mg.GetTx(job.TxID)
+	if err != nil {
+		log.Println(err)
+		retryJob(mg, job, err)
+		return
+	}
+	tx, _ := result.(model.Tx)
+	if tx.Status == model.StatusSourceConfirmed { // later states are resumed by WatchDisbursements
+		disburse(&tx)
+		if result, err = mg.GetTx(job.TxID); err != nil {
+			log.Println(err)
+			retryJob(mg, job, err)
+			return
+		}
+		tx, _ = result.(model.Tx)
+	}
+	if tx.Status == model.StatusSourceConfirmed { // the payout did not start, or another run holds it
+		retryJob(mg, job, fmt.Errorf("payout of swap %s did not start", tx.ID.Hex()))
+		return
+	}
+	finishJob(mg, job, model.JobStatusDone)
+}
@@ -0,0 +1,151 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+)
+
+// EndpointStatus is the last probed state of one node endpoint
+type EndpointStatus struct {
+	URL     string `json:"url"`
+	Head    int64  `json:"head"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// EndpointsStatus is the state of the endpoints of one network, reported on the health check
+type EndpointsStatus struct {
+	Active    string           `json:"active"`
+	Endpoints []EndpointStatus `json:"endpoints"`
+}
+
+// endpointProbe returns the latest block height served by the endpoint
+type endpointProbe func(ctx context.Context, url string) (int64, error)
+
+// endpointPool is the ordered list of node endpoints of one network. The active endpoint
+// is kept while it stays healthy, otherwise the first healthy one in order takes over.
+// An endpoint is unhealthy when it does not answer or its head lags the best head by
+// more than maxLag blocks.
+type endpointPool struct {
+	mu        sync.RWMutex
+	network   string
+	urls      []string
+	maxLag    int64
+	probe     endpointProbe
+	endpoints []EndpointStatus
+	active    int
+}
+
+func newEndpointPool(network string, urls []string, maxLag int64, probe endpointProbe) (*endpointPool, error) {
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%s: no node endpoints configured", network)
+	}
+	p := &endpointPool{
+		network:   network,
+		urls:      urls,
+		maxLag:    maxLag,
+		probe:     probe,
+		endpoints: make([]EndpointStatus, len(urls)),
+	}
+	for i, u := range urls {
+		p.endpoints[i].URL = redactURL(u)
+	}
+	return p, nil
+}
+
+// Active returns the url of the active endpoint
+func (p *endpointPool) Active() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.urls[p.active]
+}
+
+// Probe refreshes the head of every endpoint and selects the active one.
+// Returns whether the active endpoint changed.
+func (p *endpointPool) Probe(ctx context.Context) (bool, error) {
+	heads := make([]int64, len(p.urls))
+	errs := make([]error, len(p.urls))
+	var best int64
+	for i, u := range p.urls {
+		heads[i], errs[i] = p.probe(ctx, u)
+		if errs[i] == nil && heads[i] > best {
+			best = heads[i]
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.endpoints {
+		e := &p.endpoints[i]
+		e.Head, e.Healthy, e.Error = heads[i], true, ""
+		switch {
+		case errs[i] != nil:
+			e.Healthy, e.Error = false, errs[i].Error()
+		case p.maxLag > 0 && best-heads[i] > p.maxLag:
+			e.Healthy, e.Error = false, fmt.Sprintf("head lags %d blocks behind", best-heads[i])
+		}
+		if !e.Healthy {
+			log.Printf("%s: endpoint %s is unhealthy: %s\n", p.network, e.URL, e.Error)
+		}
+	}
+	if p.endpoints[p.active].Healthy {
+		return false, nil
+	}
+	return p.selectHealthy()
+}
+
+// MarkDown marks the endpoint unhealthy and fails over to the next healthy one.
+// Returns the url of the new active endpoint.
+func (p *endpointPool) MarkDown(u string, cause error) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.urls {
+		if p.urls[i] == u {
+			p.endpoints[i].Healthy, p.endpoints[i].Error = false, cause.Error()
+		}
+	}
+	if _, err := p.selectHealthy(); err != nil {
+		return "", err
+	}
+	return p.urls[p.active], nil
+}
+
+// selectHealthy makes the first healthy endpoint active. The lock must be held.
+func (p *endpointPool) selectHealthy() (bool, error) {
+	for i := range p.endpoints {
+		if p.endpoints[i].Healthy {
+			changed := i != p.active
+			if changed {
+				log.Printf("%s: failing over from %s to %s\n", p.network, p.endpoints[p.active].URL, p.endpoints[i].URL)
+			}
+			p.active = i
+			return changed, nil
+		}
+	}
+	return false, fmt.Errorf("%s: no healthy node endpoint", p.network)
+}
+
+// Status returns a copy of the endpoint states
+func (p *endpointPool) Status() EndpointsStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	endpoints := make([]EndpointStatus, len(p.endpoints))
+	copy(endpoints, p.endpoints)
+	return EndpointsStatus{
+		Active:    p.endpoints[p.active].URL,
+		Endpoints: endpoints,
+	}
+}
+
+// redactURL drops the path, query and credentials of an endpoint url,
+// node providers put their api keys there
+func redactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" { // host:port of grpc endpoints
+		return u
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
@@ -0,0 +1,101 @@
+package chain
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/client/grpc/tmservice"
+	"github.com/konstellation/swap/internal/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// grpcClient is the long lived connection to the active knstl grpc endpoint. grpc reconnects
+// it by itself with backoff, it is only replaced when the active endpoint fails over.
+type grpcClient struct {
+	mu   sync.RWMutex
+	url  string
+	conn *grpc.ClientConn
+	opts []grpc.DialOption
+}
+
+// grpcDialOptions returns the transport and keepalive options of the knstl grpc connections.
+// Nodes drop clients pinging more often than every 5 minutes, the keepalive stays above that.
+func grpcDialOptions(useTLS bool) []grpc.DialOption {
+	creds := grpc.WithInsecure()
+	if useTLS {
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	return []grpc.DialOption{
+		creds,
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    util.KnstlGrpcKeepaliveSeconds * time.Second,
+			Timeout: util.KnstlGrpcKeepaliveTimeoutSeconds * time.Second,
+		}),
+	}
+}
+
+func newGrpcClient(url string, opts []grpc.DialOption) (*grpcClient, error) {
+	c := &grpcClient{opts: opts}
+	if err := c.Connect(url); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Connect points the client at the url and closes the previous connection.
+// The dial does not block, calls wait for the connection up to their deadline.
+func (c *grpcClient) Connect(url string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil && c.url == url {
+		return nil
+	}
+	conn, err := grpc.Dial(url, c.opts...)
+	if err != nil {
+		return err
+	}
+	old := c.conn
+	c.url, c.conn = url, conn
+	if old != nil {
+		if err := old.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+	log.Println("Konstellation: grpc client of", redactURL(url))
+	return nil
+}
+
+// Conn returns the shared connection
+func (c *grpcClient) Conn() *grpc.ClientConn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn
+}
+
+// grpcCallContext bounds a grpc call with the call deadline
+func grpcCallContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), util.KnstlGrpcCallTimeoutSeconds*time.Second)
+}
+
+// probeKnstlGrpc returns the probe of the latest height served by a konstellation grpc endpoint
+func probeKnstlGrpc(opts []grpc.DialOption) endpointProbe {
+	return func(ctx context.Context, url string) (int64, error) {
+		ctx, cancel := context.WithTimeout(ctx, util.EndpointProbeTimeoutSeconds*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, url, append([]grpc.DialOption{grpc.WithBlock()}, opts...)...)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		res, err := tmservice.NewServiceClient(conn).GetLatestBlock(ctx, &tmservice.GetLatestBlockRequest{})
+		if err != nil {
+			return 0, err
+		}
+		return res.Block.Header.Height, nil
+	}
+}
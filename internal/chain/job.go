@@ -0,0 +1,31 @@
+package chain
+
+import (
+	"log"
+	"time"
+
+	"github.com/konstellation/swap/internal/model"
+	"github.com/konstellation/swap/internal/mongo"
+	"github.com/konstellation/swap/internal/util"
+)
+
+// retryJob releases the lease and runs the job again after JobRetryMinutes
+func retryJob(mg *mongo.Connection, job *model.Job, jobErr error) {
+	if jobErr != nil {
+		job.LastError = jobErr.Error()
+	}
+	job.NextRunAt = time.Now().Add(util.JobRetryMinutes * time.Minute)
+	job.LeasedUntil = time.Time{}
+	if _, err := mg.UpdateJob(job); err != nil {
+		log.Println(err)
+	}
+}
+
+// finishJob releases the lease and ends the job with the status
+func finishJob(mg *mongo.Connection, job *model.Job, status string) {
+	job.Status = status
+	job.LeasedUntil = time.Time{}
+	if _, err := mg.UpdateJob(job); err != nil {
+		log.Println(err)
+	}
+}
@@ -2,17 +2,26 @@ package chain
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cosmos/cosmos-sdk/client/tx"
 	cryptokeyring "github.com/cosmos/cosmos-sdk/crypto/keyring"
 	"github.com/cosmos/cosmos-sdk/simapp"
 	"github.com/cosmos/cosmos-sdk/types"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
+	txtypes "github.com/cosmos/cosmos-sdk/types/tx"
+	"github.com/cosmos/cosmos-sdk/types/tx/signing"
+	authsigning "github.com/cosmos/cosmos-sdk/x/auth/signing"
 	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
 	banktypes "github.com/cosmos/cosmos-sdk/x/bank/types"
 	"github.com/ethereum/go-ethereum/common"
@@ -23,78 +32,362 @@ import (
 	"github.com/konstellation/swap/internal/mongo"
 	"github.com/konstellation/swap/internal/util"
 	"github.com/shopspring/decimal"
+	abcitypes "github.com/tendermint/tendermint/abci/types"
 	tenderminthttp "github.com/tendermint/tendermint/rpc/client/http"
 	tendermintrpctypes "github.com/tendermint/tendermint/rpc/core/types"
+	tmtypes "github.com/tendermint/tendermint/types"
 	mongodrv "go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
-const amountKnstlUnit = "1000000"
-
 var (
 	cryptoKeyring cryptokeyring.Keyring
 	keyringInfo   cryptokeyring.Info
 	Denom         = "udarc"
 	ChainID       = "darchub"
+
+	knstlTxDecoder = simapp.MakeTestEncodingConfig().TxConfig.TxDecoder()
+
+	// errBroadcastPending is returned when a recorded tx may not have reached the mempool
+	errBroadcastPending = fmt.Errorf("signed tx is recorded but its broadcast failed")
+	// errTxLookup is returned when the node could not be asked for a tx
+	errTxLookup = fmt.Errorf("failed to look up knstl tx")
 )
 
 type KnstlConnection struct {
-	conn         *tenderminthttp.HTTP
-	resChan      <-chan tendermintrpctypes.ResultEvent
-	bscConn      *BSCConnection
-	MongoDB      *mongo.Connection
-	swapAddr     string
-	msgChan      chan string
-	keyring      cryptokeyring.Info
-	knstlGrpcUrl string
-	knstlUrl     string
+	mu            sync.RWMutex
+	conn          *tenderminthttp.HTTP
+	rpcEndpoints  *endpointPool
+	grpcEndpoints *endpointPool
+	resChan       <-chan tendermintrpctypes.ResultEvent
+	blockChan     <-chan tendermintrpctypes.ResultEvent
+	stream        *subscriptionHealth
+	bscConn       *BSCConnection
+	MongoDB       *mongo.Connection
+	swapAddr      string
+	msgChan       chan string
+	keyring       cryptokeyring.Info
+	startHeight   int64
+	confirmations int64
+	sequences     *sequenceManager
+	gasAdjustment float64
+	gasPrices     string
+	grpcConn      *grpcClient
+	payouts       *payoutGuard
 }
 
-func (k *KnstlConnection) InitConnection(_ context.Context, c *config.KnstlInfo, mg *mongo.Connection, bscConn *BSCConnection, msgChan chan string) error {
-	var err error
-
-	cryptoKeyring, err = keyring.NewKeyring("portal")
+// loadSwapKey opens the configured keyring and loads the swap key, importing it from the
+// armor file or recovering it from the mnemonic when the keyring does not hold it yet
+func loadSwapKey(c *config.KnstlInfo) error {
+	if c.KnstlKeyringBackend == "" {
+		c.KnstlKeyringBackend = cryptokeyring.BackendMemory
+	}
+	if c.KnstlKeyName == "" {
+		c.KnstlKeyName = "portal_keyring"
+	}
+	passphrase, err := keyring.ReadPassphrase(c.KnstlKeyringPassphraseFd, c.KnstlKeyringPassphraseFile)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-
-	keyringInfo, err = keyring.CreateKey(cryptoKeyring, "portal_keyring", c.KnstlSwapMnemonic)
+	armorPassphrase := passphrase
+	if c.KnstlKeyArmorPassphraseFile != "" {
+		armorPassphrase, err = keyring.ReadPassphrase(0, c.KnstlKeyArmorPassphraseFile)
+		if err != nil {
+			return err
+		}
+	}
+	cryptoKeyring, err = keyring.NewKeyring("portal", c.KnstlKeyringBackend, c.KnstlKeyringDir, passphrase)
 	if err != nil {
-		log.Fatalf("Failed to create keyring: %v", err)
+		return err
 	}
+	keyringInfo, err = keyring.LoadKey(cryptoKeyring, c.KnstlKeyName, c.KnstlKeyArmorFile, armorPassphrase, c.KnstlSwapMnemonic)
+	return err
+}
+
+func (k *KnstlConnection) InitConnection(_ context.Context, c *config.KnstlInfo, mg *mongo.Connection, bscConn *BSCConnection, msgChan chan string) error {
+	var err error
+
+	if err := loadSwapKey(c); err != nil {
+		log.Fatalf("Failed to load swap key: %v", err)
+	}
+	log.Println("Konstellation: swap key", keyringInfo.GetAddress().String(), "from the", c.KnstlKeyringBackend, "keyring")
 
 	k.bscConn = bscConn
 	k.msgChan = msgChan
 	k.swapAddr = c.KnstlSwapAddr
 	k.keyring = keyringInfo
 	k.MongoDB = mg
-	k.knstlGrpcUrl = c.KnstlNodeGrpcUrl
-	k.knstlUrl = c.KnstlNodeUrl
+	k.startHeight = c.KnstlStartHeight
+	k.confirmations = c.KnstlConfirmations
+	k.payouts = newPayoutGuard()
+
+	if err := k.loadGasConfig(c); err != nil {
+		log.Fatalln("Konstellation: ", err)
+	}
+
+	grpcOpts := grpcDialOptions(c.KnstlGrpcTLS)
+	k.grpcEndpoints, err = newEndpointPool("Konstellation gRPC", c.KnstlNodeGrpcUrls, c.KnstlMaxHeadLag, probeKnstlGrpc(grpcOpts))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if _, err := k.grpcEndpoints.Probe(context.Background()); err != nil {
+		log.Fatalln(err)
+	}
+	k.grpcConn, err = newGrpcClient(k.grpcEndpoints.Active(), grpcOpts)
+	if err != nil {
+		log.Fatalln("Konstellation: ", err)
+	}
+	if err := k.loadDenomInfo(c); err != nil {
+		log.Fatalln("Konstellation: ", err)
+	}
+
+	k.sequences = newSequenceManager(k.swapAccount, mg, keyringInfo.GetAddress().String())
 
-	k.conn, err = tenderminthttp.New(k.knstlUrl, "/websocket")
+	k.stream = newSubscriptionHealth("knstl_subscription")
+	k.rpcEndpoints, err = newEndpointPool("Konstellation RPC", c.KnstlNodeUrls, c.KnstlMaxHeadLag, probeKnstlRpc)
 	if err != nil {
-		log.Fatalln("Konstellation: Failed to initialize RPC Connection: ", err)
+		log.Fatalln(err)
 	}
+	if err := k.connectBest(); err != nil {
+		log.Fatalln("Konstellation: ", err)
+	}
+
+	return nil
+}
 
-	if err := k.conn.Start(); err != nil {
-		log.Fatalln("Konstellation: Failed to initialize Websocket Connection: ", err)
+// rpc returns the tendermint client of the active rpc endpoint
+func (k *KnstlConnection) rpc() *tenderminthttp.HTTP {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.conn
+}
+
+// Subscription reports the health of the websocket event stream
+func (k *KnstlConnection) Subscription() SubscriptionStatus {
+	return k.stream.Status()
+}
+
+// Endpoints reports the state of the konstellation rpc and grpc node endpoints
+func (k *KnstlConnection) Endpoints() map[string]EndpointsStatus {
+	return map[string]EndpointsStatus{
+		"rpc":  k.rpcEndpoints.Status(),
+		"grpc": k.grpcEndpoints.Status(),
 	}
+}
 
-	log.Println("Konstellation: RPC Websocket connection established")
+// probeKnstlRpc returns the latest height of a synced konstellation node
+func probeKnstlRpc(ctx context.Context, url string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, util.EndpointProbeTimeoutSeconds*time.Second)
+	defer cancel()
+	client, err := tenderminthttp.New(url, "/websocket")
+	if err != nil {
+		return 0, err
+	}
+	status, err := client.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if status.SyncInfo.CatchingUp {
+		return 0, fmt.Errorf("node is catching up at height %d", status.SyncInfo.LatestBlockHeight)
+	}
+	return status.SyncInfo.LatestBlockHeight, nil
+}
 
-	query := fmt.Sprintf(`tm.event='Tx' AND transfer.recipient = '%s'`, k.swapAddr)
-	k.resChan, err = k.conn.WSEvents.Subscribe(
-		context.Background(),
-		"swap",
-		query,
-	)
+// connectBest probes the rpc endpoints and connects to the active one,
+// failing over to the next healthy endpoint when the connection fails
+func (k *KnstlConnection) connectBest() error {
+	if _, err := k.rpcEndpoints.Probe(context.Background()); err != nil {
+		return err
+	}
+	url := k.rpcEndpoints.Active()
+	for {
+		err := k.connect(url)
+		if err == nil {
+			return nil
+		}
+		log.Println("Konstellation: failed to connect to", redactURL(url), err)
+		if url, err = k.rpcEndpoints.MarkDown(url, err); err != nil {
+			return err
+		}
+	}
+}
+
+// connect opens the websocket of the rpc endpoint, subscribes to the deposits
+// and replaces the active client
+func (k *KnstlConnection) connect(url string) error {
+	conn, err := tenderminthttp.New(url, "/websocket")
 	if err != nil {
-		log.Fatalln("Konstellation: Failed to subscribe: ", err)
+		return fmt.Errorf("failed to initialize RPC Connection: %v", err)
+	}
+	if err := conn.Start(); err != nil {
+		return fmt.Errorf("failed to initialize Websocket Connection: %v", err)
 	}
+	log.Println("Konstellation: RPC Websocket connection established with", redactURL(url))
 
+	resChan, blockChan, err := k.subscribe(conn)
+	if err != nil {
+		_ = conn.Stop()
+		return err
+	}
+
+	k.mu.Lock()
+	old := k.conn
+	k.conn, k.resChan, k.blockChan = conn, resChan, blockChan
+	k.mu.Unlock()
+	if old != nil {
+		_ = old.Stop()
+	}
+	k.stream.Connected()
+	return nil
+}
+
+// subscribe subscribes the client to the deposits to the swap address
+// and to the new block headers used as the liveness heartbeat
+func (k *KnstlConnection) subscribe(conn *tenderminthttp.HTTP) (<-chan tendermintrpctypes.ResultEvent, <-chan tendermintrpctypes.ResultEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), util.EndpointProbeTimeoutSeconds*time.Second)
+	defer cancel()
+	query := fmt.Sprintf(`tm.event='Tx' AND transfer.recipient = '%s'`, k.swapAddr)
+	resChan, err := conn.WSEvents.Subscribe(ctx, "swap", query, util.KnstlEventBuffer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to subscribe: %v", err)
+	}
 	log.Println(fmt.Sprintf("Konstellation: Subscribed to %s", query))
+	blockChan, err := conn.WSEvents.Subscribe(ctx, "swap", tmtypes.QueryForEvent(tmtypes.EventNewBlockHeader).String())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to subscribe to new blocks: %v", err)
+	}
+	return resChan, blockChan, nil
+}
 
-	return err
+// checkLiveness resubscribes when no new block arrived on the stream for too long,
+// a dropped websocket otherwise goes quiet without an error
+func (k *KnstlConnection) checkLiveness() {
+	status := k.stream.Status()
+	last := status.LastEventAt
+	if status.ConnectedAt.After(last) {
+		last = status.ConnectedAt
+	}
+	if quiet := time.Since(last); quiet > util.KnstlHeartbeatTimeoutSeconds*time.Second {
+		err := fmt.Errorf("no new block for %s", quiet.Round(time.Second))
+		log.Println("Konstellation: event stream is stale:", err)
+		k.stream.Failed(err)
+		k.resubscribe()
+	}
+}
+
+// resubscribe renews the subscriptions on the active client, reconnecting when that fails,
+// then backfills the deposits missed meanwhile
+func (k *KnstlConnection) resubscribe() {
+	conn := k.rpc()
+	ctx, cancel := context.WithTimeout(context.Background(), util.EndpointProbeTimeoutSeconds*time.Second)
+	err := conn.UnsubscribeAll(ctx, "swap")
+	cancel()
+	if err != nil {
+		log.Println("Konstellation: failed to unsubscribe:", err)
+	}
+	resChan, blockChan, err := k.subscribe(conn)
+	if err != nil {
+		log.Println("Konstellation: resubscribe failed:", err)
+		k.stream.Failed(err)
+		k.reconnect()
+		return
+	}
+	k.mu.Lock()
+	k.resChan, k.blockChan = resChan, blockChan
+	k.mu.Unlock()
+	k.stream.Connected()
+	if err := k.Backfill(); err != nil {
+		log.Println(err)
+	}
+}
+
+// reconnect connects to the best rpc endpoint with exponential backoff until it succeeds,
+// then backfills the deposits missed meanwhile
+func (k *KnstlConnection) reconnect() {
+	for attempt := 0; ; attempt++ {
+		err := k.connectBest()
+		if err == nil {
+			break
+		}
+		k.stream.Failed(err)
+		delay := backoffDelay(attempt)
+		log.Println("Konstellation: reconnect failed:", err, "retrying in", delay)
+		time.Sleep(delay)
+	}
+	if err := k.Backfill(); err != nil {
+		log.Println(err)
+	}
+}
+
+// probeEndpoints refreshes both endpoint pools and moves the subscription
+// when the active rpc endpoint changed
+func (k *KnstlConnection) probeEndpoints() {
+	if changed, err := k.grpcEndpoints.Probe(context.Background()); err != nil {
+		log.Println(err)
+	} else if changed {
+		if err := k.grpcConn.Connect(k.grpcEndpoints.Active()); err != nil {
+			log.Println(err)
+		}
+	}
+	changed, err := k.rpcEndpoints.Probe(context.Background())
+	if err != nil {
+		log.Println(err)
+	} else if changed {
+		k.reconnect()
+	}
+}
+
+// loadGasConfig reads the payout gas adjustment and gas prices
+func (k *KnstlConnection) loadGasConfig(c *config.KnstlInfo) error {
+	k.gasAdjustment = c.KnstlGasAdjustment
+	if k.gasAdjustment <= 0 {
+		k.gasAdjustment = 1
+	}
+	prices, err := types.ParseDecCoins(c.KnstlGasPrices)
+	if err != nil {
+		return fmt.Errorf("invalid KNSTL_GAS_PRICES %q: %v", c.KnstlGasPrices, err)
+	}
+	if prices.IsZero() { // an empty list parses to no coins, payouts would be sent without fees
+		return fmt.Errorf("invalid KNSTL_GAS_PRICES %q: no gas price is set", c.KnstlGasPrices)
+	}
+	k.gasPrices = c.KnstlGasPrices
+	log.Println("Konstellation: gas adjustment", k.gasAdjustment, "gas prices", k.gasPrices)
+	return nil
+}
+
+// loadDenomInfo reads the decimals of the denom from the bank metadata and checks them against the config
+func (k *KnstlConnection) loadDenomInfo(c *config.KnstlInfo) error {
+	ctx, cancel := grpcCallContext()
+	defer cancel()
+	res, err := banktypes.NewQueryClient(k.grpcConn.Conn()).DenomMetadata(ctx, &banktypes.QueryDenomMetadataRequest{Denom: Denom})
+	if grpcstatus.Code(err) == codes.NotFound { // metadata is optional on chain
+		if c.KnstlDenomDecimals != 0 {
+			util.AmountKnstlDecimals = int32(c.KnstlDenomDecimals)
+		}
+		log.Println("Konstellation: no metadata for", Denom, "using", util.AmountKnstlDecimals, "decimals")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("denom metadata of %s: %v", Denom, err)
+	}
+
+	decimals := -1
+	for _, unit := range res.Metadata.DenomUnits {
+		if unit.Denom == res.Metadata.Display {
+			decimals = int(unit.Exponent)
+		}
+	}
+	if decimals < 0 {
+		return fmt.Errorf("denom metadata of %s has no display unit", Denom)
+	}
+	log.Printf("Konstellation: denom %s (%s) with %d decimals\n", Denom, res.Metadata.Display, decimals)
+	if c.KnstlDenomDecimals != 0 && c.KnstlDenomDecimals != decimals {
+		return fmt.Errorf("denom %s has %d decimals, expected %d", Denom, decimals, c.KnstlDenomDecimals)
+	}
+	util.AmountKnstlDecimals = int32(decimals)
+	return nil
 }
 
 type Log struct {
@@ -108,208 +401,595 @@ type Log struct {
 }
 
 func (k *KnstlConnection) HandleMessage() {
+	if err := k.Backfill(); err != nil {
+		log.Println(err)
+	}
+	ticker := time.NewTicker(util.SleepTimeSeconds * time.Second)
+	defer ticker.Stop()
+	probeTicker := time.NewTicker(util.EndpointProbeSeconds * time.Second)
+	defer probeTicker.Stop()
 	for {
-		msg := <-k.resChan
-		log.Printf("****** Get source knstl transaction data: %+v ******\n", msg.Events)
-		if len(msg.Events["transfer.amount"]) == 1 {
-			log.Println("There is no fee for transaction")
-			continue
+		select {
+		case msg := <-k.resChan: // only records deposits, payouts run in ProcessPayouts
+			k.stream.Event()
+			deposits := k.eventDeposits(msg)
+			for _, deposit := range deposits {
+				k.handleDeposit(deposit)
+			}
+			if len(deposits) > 0 {
+				k.saveCursor(deposits[0].Height)
+			}
+		case <-k.blockChan:
+			k.stream.Event()
+		case <-ticker.C:
+			k.confirmPendingDeposits()
+			k.checkLiveness()
+		case <-probeTicker.C:
+			k.probeEndpoints()
 		}
-		amountStr := strings.ReplaceAll(msg.Events["transfer.amount"][1], "udarc", "")
-		amount, err := decimal.NewFromString(amountStr)
+	}
+}
+
+// Backfill processes the deposits from the saved height cursor up to the latest block
+// with a paginated tx_search
+func (k *KnstlConnection) Backfill() error {
+	status, err := k.rpc().Status(context.Background())
+	if err != nil {
+		return err
+	}
+	head := status.SyncInfo.LatestBlockHeight
+	from := k.startHeight
+	cursor, err := k.MongoDB.GetCursor(model.CursorKnstlTxs)
+	if err == nil {
+		from = int64(cursor.Height)
+	} else if err != mongodrv.ErrNoDocuments {
+		return err
+	}
+	if from == 0 { // first run without KNSTL_START_HEIGHT, start from the head
+		log.Println("Konstellation: no height cursor, starting from height", head)
+		return k.MongoDB.SaveCursor(model.CursorKnstlTxs, uint64(head))
+	}
+
+	log.Println("Konstellation: backfilling txs from height", from, "to", head)
+	query := fmt.Sprintf(`transfer.recipient = '%s' AND tx.height >= %d AND tx.height <= %d`, k.swapAddr, from, head)
+	perPage := util.KnstlBackfillPerPage
+	for page := 1; ; page++ {
+		res, err := k.rpc().TxSearch(context.Background(), query, false, &page, &perPage, "asc")
 		if err != nil {
-			log.Println(err)
-			continue
+			return err
 		}
-		log.Println("Knstl amount decimal conversion: ", amount)
-		knstlUnit, err := decimal.NewFromString(amountKnstlUnit)
-		if err != nil {
-			log.Println(err)
-			continue
+		for _, tx := range res.Txs {
+			if tx.TxResult.Code == 0 {
+				for _, deposit := range k.deposits(tx.Hash.String(), tx.Height, txMemo(tx.Tx), tx.TxResult.Events) {
+					k.handleDeposit(deposit)
+				}
+			}
+			k.saveCursor(tx.Height)
 		}
-		log.Println("Knstl basic unit amount decimal conversion: ", knstlUnit)
-		amountInDB := amount.Div(knstlUnit)
-
-		log.Println("Checking if address in POST request is blacklist address")
-		filter := map[string]string{
-			"address": msg.Events["message.sender"][0],
+		if page*perPage >= res.TotalCount {
+			break
 		}
-		blacklistResult, err := k.MongoDB.FindBlacklist(filter)
-		if err != nil {
-			if err != mongodrv.ErrNoDocuments {
-				log.Println(err)
-				continue
-			}
+	}
+	log.Println("Konstellation: backfill finished at height", head)
+	return k.MongoDB.SaveCursor(model.CursorKnstlTxs, uint64(head))
+}
+
+// knstlDeposit is one transfer of Denom to the swap address
+type knstlDeposit struct {
+	Hash   string
+	Height int64
+	Index  int // position among the transfers to the swap address within the tx
+	Sender string
+	Amount *big.Int
+	Memo   string // swap reference code put in the memo by the user
+}
+
+// eventDeposits decodes the deposits of a tx delivered by the subscription
+func (k *KnstlConnection) eventDeposits(msg tendermintrpctypes.ResultEvent) []knstlDeposit {
+	data, ok := msg.Data.(tmtypes.EventDataTx)
+	if !ok {
+		log.Printf("Konstellation: unexpected event data %T\n", msg.Data)
+		return nil
+	}
+	if data.Result.Code != 0 {
+		return nil
+	}
+	return k.deposits(fmt.Sprintf("%X", tmtypes.Tx(data.Tx).Hash()), data.Height, txMemo(data.Tx), data.Result.Events)
+}
+
+// txMemo decodes the memo of a tx
+func txMemo(txBytes []byte) string {
+	decoded, err := knstlTxDecoder(txBytes)
+	if err != nil {
+		log.Println("Failed to decode knstl tx:", err)
+		return ""
+	}
+	memoTx, ok := decoded.(types.TxWithMemo)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(memoTx.GetMemo())
+}
+
+// deposits extracts every transfer to the swap address from the tx events.
+// Transfers of MsgMultiSend outputs carry no sender, the sender of the single
+// input is used then and ambiguous multi-input transfers are skipped.
+func (k *KnstlConnection) deposits(hash string, height int64, memo string, events []abcitypes.Event) []knstlDeposit {
+	var senders []string
+	for _, event := range events {
+		if event.Type != "message" {
+			continue
 		}
-		isblacklistAmountbigger := false
-		if blacklistResult != nil {
-			threshold, _ := decimal.NewFromString(util.BlacklistAllowThresholdAmount)
-			if amountInDB.GreaterThanOrEqual(threshold) {
-				err = fmt.Errorf("blacklist address amount request is more than 1000000 DARC")
-				log.Println(err)
-				isblacklistAmountbigger = true
+		for _, attr := range event.Attributes {
+			if string(attr.Key) == "sender" && !containsString(senders, string(attr.Value)) {
+				senders = append(senders, string(attr.Value))
 			}
 		}
+	}
 
-		filter = map[string]string{
-			"from_address":                  msg.Events["message.sender"][0],
-			"source_network":                "knstl",
-			"destination_network":           "bsc",
-			"source_network_completed":      "false",
-			"destination_network_completed": "false",
-			"amount":                        amountInDB.String(),
-		}
-		log.Printf("Knstl transaction data to search in the DB: %+v\n", filter)
-		result, err := k.MongoDB.FindTx(filter) // this case cannot update transaction complete case
-		if err != nil {
-			log.Println(err)
+	var deposits []knstlDeposit
+	for _, event := range events {
+		if event.Type != banktypes.EventTypeTransfer {
 			continue
 		}
-		log.Printf("Knstl transaction POST request is found in DB: %+v\n", result)
-		tx, ok := result.(model.Tx) // this case cannot update transaction complete case
-		if !ok {
-			log.Println("The result is not transaction type")
+		var recipient, sender, amount string
+		for _, attr := range event.Attributes {
+			switch string(attr.Key) {
+			case banktypes.AttributeKeyRecipient:
+				recipient = string(attr.Value)
+			case banktypes.AttributeKeySender:
+				sender = string(attr.Value)
+			case types.AttributeKeyAmount:
+				amount = string(attr.Value)
+			}
+		}
+		if recipient != k.swapAddr {
 			continue
 		}
-		log.Printf("Knstl transaction data in the DB: %+v\n", tx)
-		tx.SourceNetworkHash = msg.Events["tx.hash"][0]
-		tx.SourceNetworkCompleted = true
-		tx.UpdatedAt = time.Now().Format(util.TimeFormat)
-
-		if isblacklistAmountbigger { // Update transaction status is completed
-			tx.Completed = true
+		index := len(deposits)
+		deposits = append(deposits, knstlDeposit{Hash: hash, Height: height, Index: index, Memo: memo})
+		if sender == "" && len(senders) == 1 {
+			sender = senders[0]
 		}
-		_, err = k.MongoDB.UpdateTx(&tx) // this case cannot update transaction complete case
+		if sender == "" {
+			log.Println("Knstl transfer", index, "of", hash, "has no single sender")
+			continue
+		}
+		coins, err := types.ParseCoinsNormalized(amount)
 		if err != nil {
-			log.Println(err)
+			log.Println("Invalid knstl transfer amount", amount, "of", hash, err)
 			continue
 		}
-		if isblacklistAmountbigger { // Protect blacklist swap
-			log.Println("blacklist address amount request is more than 1000000 DARC. Cannot conitnue to swap")
+		if coins.AmountOf(Denom).IsZero() {
+			log.Println("Knstl transfer", index, "of", hash, "carries no", Denom, ":", amount)
 			continue
 		}
+		deposits[index].Sender = sender
+		deposits[index].Amount = coins.AmountOf(Denom).BigInt()
+	}
+	return deposits
+}
 
-		log.Printf("Knstl transaction data is updated in the DB: %+v\n", tx)
-		log.Println("The knstl source network operation is finished. $$$$$$")
-		k.bscConn.DisburseFunds(&tx)
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
 	}
+	return false
 }
 
-func (k *KnstlConnection) DisburseFunds(t *model.Tx) {
-	log.Println("Knstl token conversion start. Destination network operation. $$$$$$")
-	toAddress := t.ToAddress
-	toAddr, err := types.AccAddressFromBech32(toAddress)
-	if err != nil {
-		log.Println("Invalid corporate wallet:", t.ToAddress, err)
-		k.updateTransactionComplete(t)
+func (k *KnstlConnection) saveCursor(height int64) {
+	if err := k.MongoDB.SaveCursor(model.CursorKnstlTxs, uint64(height)); err != nil {
+		log.Println(err)
+	}
+}
+
+func (k *KnstlConnection) handleDeposit(deposit knstlDeposit) {
+	log.Printf("****** Get source knstl transaction data: %+v ******\n", deposit)
+	if deposit.Amount == nil { // kept only to hold the index of the transfer
 		return
 	}
-	log.Println("Knstl toaddress", toAddress, "Knstl AccAddressFromBech with toaddress", toAddr)
-	corporateWallet, err := types.AccAddressFromBech32(k.swapAddr)
-	if err != nil {
-		log.Println("Invalid corporate wallet:", k.swapAddr, err)
-		k.updateTransactionComplete(t)
+	processed := map[string]string{
+		"source_network_hash":  deposit.Hash,
+		"source_network_index": strconv.Itoa(deposit.Index),
+	}
+	if _, err := k.MongoDB.FindTx(processed); err == nil {
+		log.Println("Knstl transfer", deposit.Index, "of", deposit.Hash, "is already processed")
 		return
 	}
-	log.Println("Knstl swapAddr(KNSTL_CORPORATE_ADDR)", k.swapAddr, "Knstl AccAddressFromBech with swapAddr", corporateWallet)
-	knstlGrpcConn, err := grpc.Dial(k.knstlGrpcUrl, grpc.WithInsecure(), grpc.WithBlock())
+	amountInDB := util.FromBaseUnits("knstl", deposit.Amount)
+	log.Println("Knstl amount decimal conversion: ", amountInDB)
+
+	log.Println("Checking if address in POST request is blacklist address")
+	filter := map[string]string{
+		"address": deposit.Sender,
+	}
+	blacklistResult, err := k.MongoDB.FindBlacklist(filter)
 	if err != nil {
-		log.Printf("Did not connect: %v", err)
-		k.updateTransactionComplete(t)
+		if err != mongodrv.ErrNoDocuments {
+			log.Println(err)
+			return
+		}
+	}
+	isblacklistAmountbigger := false
+	if blacklistResult != nil {
+		threshold, _ := decimal.NewFromString(util.BlacklistAllowThresholdAmount)
+		if amountInDB.GreaterThanOrEqual(threshold) {
+			err = fmt.Errorf("blacklist address amount request is more than 1000000 DARC")
+			log.Println(err)
+			isblacklistAmountbigger = true
+		}
+	}
+
+	tx, err := k.matchSwap(deposit, amountInDB)
+	if err == mongodrv.ErrNoDocuments {
+		log.Println("No knstl swap request matches deposit", deposit.Hash)
+		k.recordRefund(deposit, amountInDB, nil, "no matching swap request")
 		return
 	}
-	log.Println("knstlGrpcConn", k.knstlGrpcUrl, "is connected")
-	defer knstlGrpcConn.Close()
-	knstlAcc, err := getAccount(context.Background(), knstlGrpcConn, keyringInfo.GetAddress().String())
 	if err != nil {
-		log.Printf("Failed to get account %s: %v", keyringInfo.GetAddress().String(), err)
-		k.updateTransactionComplete(t)
+		log.Println(err)
 		return
 	}
-	decimalAmount := util.GetTransactionAmount("knstl", t.Amount)
-	log.Println("user transaction total knstl amount:", decimalAmount)
-	//decimalKnstlFee := util.GetTransactionAmount("knstl", float64(util.UserKnstlTransactionFee))
-	//log.Println("user transaction fee knstl amount:", decimalKnstlFee)
-	//totalAmountDecimal := decimalAmount.Sub(decimalKnstlFee)
-	//log.Println("Total decimal amount deducting fee:", totalAmountDecimal)
+	log.Printf("Knstl transaction data in the DB: %+v\n", *tx)
+	if !tx.Status.CanTransitionTo(model.StatusSourceSeen) { // matched by the reference of a swap that is over
+		// not the deposit of the swap, only the refund tracks it
+		k.recordRefund(deposit, amountInDB, nil, "swap "+tx.ID.Hex()+" is already "+tx.Status.String())
+		return
+	}
+	tx.SourceNetworkHash = deposit.Hash
+	tx.SourceNetworkIndex = deposit.Index
+	tx.SourceNetworkHeight = uint64(deposit.Height)
+	tx.SourceSender = deposit.Sender
+	if err := updateTxStatus(k.MongoDB, tx, model.StatusSourceSeen, "deposit "+tx.SourceNetworkHash+" of "+tx.Amount+" found"); err != nil {
+		return
+	}
+	if !amountInDB.GreaterThan(decimal.RequireFromString(util.UserBscTransactionFee)) {
+		_ = updateTxStatus(k.MongoDB, tx, model.StatusRejected, "deposit does not cover the fee")
+		k.recordRefund(deposit, amountInDB, tx, "deposit does not cover the fee")
+		return
+	}
+	if isblacklistAmountbigger { // Protect blacklist swap
+		log.Println("blacklist address amount request is more than 1000000 DARC. Cannot conitnue to swap")
+		_ = updateTxStatus(k.MongoDB, tx, model.StatusRejected, "blacklisted sender above the allowed amount")
+		k.recordRefund(deposit, amountInDB, tx, "blacklisted sender above the allowed amount")
+		return
+	}
+	k.confirmDeposit(tx)
+}
 
-	msg := banktypes.NewMsgSend(corporateWallet, toAddr, types.NewCoins(types.NewCoin("udarc", types.NewIntFromUint64(decimalAmount.BigInt().Uint64()))))
-	err = msg.ValidateBasic()
+// recordRefund keeps a deposit the swap does not pay out for an operator to refund
+func (k *KnstlConnection) recordRefund(deposit knstlDeposit, amount decimal.Decimal, tx *model.Tx, reason string) {
+	r := model.NewRefund("knstl", deposit.Hash, deposit.Index)
+	r.Sender = deposit.Sender
+	r.Amount = amount.String()
+	r.Fee = util.UserKnstlTransactionFee
+	r.Reason = reason
+	recordRefund(k.MongoDB, r, tx)
+}
+
+// matchSwap finds the swap request of a deposit by the reference code in the memo first,
+// then by the sender and the exact amount. A reference match pays out the deposited amount.
+func (k *KnstlConnection) matchSwap(deposit knstlDeposit, amount decimal.Decimal) (*model.Tx, error) {
+	if deposit.Memo != "" {
+		filter := map[string]string{
+			"reference":      strings.ToUpper(deposit.Memo),
+			"source_network": "knstl",
+		}
+		result, err := k.MongoDB.FindTx(filter)
+		if err == nil {
+			tx, _ := result.(model.Tx)
+			log.Printf("Knstl deposit %s matches swap %s by reference %s\n", deposit.Hash, tx.ID.Hex(), tx.Reference)
+			if requested, err := decimal.NewFromString(tx.Amount); err != nil || !requested.Equal(amount) {
+				log.Println("Knstl swap", tx.ID.Hex(), "requested", tx.Amount, "but", amount, "is deposited")
+				tx.Amount = amount.String()
+			}
+			return &tx, nil
+		}
+		if err != mongodrv.ErrNoDocuments {
+			return nil, err
+		}
+		log.Println("No knstl swap with reference", deposit.Memo, "matching by sender and amount")
+	}
+
+	filter := map[string]string{
+		"from_address":                  deposit.Sender,
+		"source_network":                "knstl",
+		"destination_network":           "bsc",
+		"source_network_completed":      "false",
+		"destination_network_completed": "false",
+		"amount":                        amount.String(),
+	}
+	log.Printf("Knstl transaction data to search in the DB: %+v\n", filter)
+	result, err := k.MongoDB.FindTx(filter) // this case cannot update transaction complete case
 	if err != nil {
-		log.Printf("Invalid tx msg: %v", err)
-		k.updateTransactionComplete(t)
-		return
+		return nil, err
 	}
-	log.Printf("Knstl tx msg: %+v\n", msg)
-	encCfg := simapp.MakeTestEncodingConfig()
-	txBuilder := encCfg.TxConfig.NewTxBuilder()
-	txBuilder.SetGasLimit(140000)
-	txBuilder.SetFeeAmount(types.NewCoins(types.NewInt64Coin("udarc", int64(1))))
-	err = txBuilder.SetMsgs(msg)
+	tx, ok := result.(model.Tx)
+	if !ok {
+		return nil, fmt.Errorf("the result is not transaction type")
+	}
+	return &tx, nil
+}
+
+// confirmDeposit disburses the swap once its deposit is deep enough.
+// Shallower deposits stay in source_seen and are retried by confirmPendingDeposits.
+func (k *KnstlConnection) confirmDeposit(tx *model.Tx) {
+	status, err := k.rpc().Status(context.Background())
 	if err != nil {
-		log.Println("Setting messages is failed")
-		k.updateTransactionComplete(t)
+		log.Println(err)
 		return
 	}
-	txFactory := tx.Factory{}
-	txFactory = txFactory.
-		WithKeybase(cryptoKeyring).
-		WithTxConfig(encCfg.TxConfig).
-		WithAccountNumber(knstlAcc.AccountNumber).
-		WithSequence(knstlAcc.Sequence).
-		WithChainID(ChainID)
-	log.Printf("feepayer: %+v\n", txBuilder.GetTx().FeePayer())
-	log.Printf("fee: %+v\n", txBuilder.GetTx().GetFee())
-	if err := tx.Sign(txFactory, keyringInfo.GetName(), txBuilder, true); err != nil {
-		log.Println("Signing transaction is failed")
-		k.updateTransactionComplete(t)
+	depth := status.SyncInfo.LatestBlockHeight - int64(tx.SourceNetworkHeight) + 1
+	if depth < k.confirmations {
+		log.Println("Knstl deposit", tx.SourceNetworkHash, "waits for", k.confirmations, "confirmations, depth", depth)
 		return
 	}
-	txBytes, err := encCfg.TxConfig.TxEncoder()(txBuilder.GetTx())
-	if err != nil {
-		log.Println("Encoding transaction is failed")
-		k.updateTransactionComplete(t)
+	if err := updateTxStatus(k.MongoDB, tx, model.StatusSourceConfirmed, "deposit "+tx.SourceNetworkHash+" confirmed"); err != nil {
 		return
 	}
-	res, err := k.conn.BroadcastTxSync(context.Background(), txBytes)
+
+	log.Printf("Knstl transaction data is updated in the DB: %+v\n", *tx)
+	log.Println("The knstl source network operation is finished. $$$$$$")
+	enqueuePayout(k.MongoDB, model.QueueBscPayout, tx)
+}
+
+// ProcessPayouts runs the bsc payouts of the confirmed knstl deposits off the event loop
+func (k *KnstlConnection) ProcessPayouts() {
+	k.bscConn.ResumeDisbursements()
+	processPayouts(k.MongoDB, model.QueueBscPayout, "knstl", k.bscConn.DisburseFunds)
+}
+
+func (k *KnstlConnection) confirmPendingDeposits() {
+	filter := map[string]string{
+		"source_network": "knstl",
+		"status":         model.StatusSourceSeen.String(),
+	}
+	txs, err := k.MongoDB.FindTxs(filter)
 	if err != nil {
-		log.Println("Broadcasting transaction is failed:", err)
-		k.updateTransactionComplete(t)
+		log.Println(err)
 		return
 	}
-	log.Printf("Knstl transaction response: %+v\n", *res)
+	for i := range txs {
+		k.confirmDeposit(&txs[i])
+	}
+}
+
+func (k *KnstlConnection) DisburseFunds(t *model.Tx) {
+	log.Println("Knstl token conversion start. Destination network operation. $$$$$$")
+	if !k.payouts.acquire(t.ID) {
+		log.Println("Knstl payout of swap", t.ID.Hex(), "is already running")
+		return
+	}
+	defer k.payouts.release(t.ID)
+	if t.Status != model.StatusDisbursing && t.Status != model.StatusDisbursed {
+		if err := updateTxStatus(k.MongoDB, t, model.StatusDisbursing, "disbursing knstl funds"); err != nil {
+			return
+		}
+	}
+	d, err := openDisbursement(k.MongoDB, t)
+	if err != nil { // a payout recorded before may be in a block, retried by WatchDisbursements
+		log.Println("Failed to open disbursement of swap", t.ID.Hex(), ":", err)
+		return
+	}
+
+	var res *tendermintrpctypes.ResultBroadcastTx
+	if d.RawTx != "" { // signed before a restart, never build a second payout
+		log.Println("Re-broadcasting signed knstl tx", d.Hash, "of swap", t.ID.Hex())
+		txBytes, err := hex.DecodeString(d.RawTx)
+		if err != nil { // the recorded tx may be in a block, never fail a payout that can still land
+			log.Println("Failed to decode signed knstl tx", d.Hash, "of swap", t.ID.Hex(), ":", err)
+			return
+		}
+		res, err = k.broadcast(txBytes)
+		if err != nil { // the first broadcast may already be in a block
+			log.Println("Broadcasting transaction is failed:", err)
+			included, lost, settleErr := k.settleBroadcast(txBytes, d.Hash)
+			switch {
+			case settleErr != nil:
+				log.Println(settleErr)
+				return
+			case lost:
+				k.failTransaction(t, "sequence of "+d.Hash+" is used by another tx")
+				return
+			case !included: // picked up again by WatchDisbursements
+				log.Println("Knstl payout", d.Hash, "of swap", t.ID.Hex(), "is kept for rebroadcast")
+				return
+			}
+		} else {
+			k.releaseSequence(txBytes)
+		}
+	} else {
+		sign := func() ([]byte, uint64, error) { return k.signPayout(t) }
+		record := func(txBytes []byte) error {
+			d.RawTx = hex.EncodeToString(txBytes)
+			d.Hash = fmt.Sprintf("%X", tmtypes.Tx(txBytes).Hash())
+			d.Status = model.DisbursementSigned
+			if _, err := k.MongoDB.UpdateDisbursement(d); err != nil {
+				return fmt.Errorf("failed to record signed tx: %v", err)
+			}
+			return nil
+		}
+		res, err = k.signAndBroadcast(sign, record)
+		if errors.Is(err, errBroadcastPending) { // picked up again by WatchDisbursements
+			log.Println(err, "- knstl payout", d.Hash, "of swap", t.ID.Hex(), "is kept for rebroadcast")
+			return
+		}
+		if err != nil {
+			log.Println(err)
+			k.failTransaction(t, err.Error())
+			return
+		}
+	}
+	if res != nil {
+		log.Printf("Knstl transaction response: %+v\n", *res)
+	}
+	d.Status = model.DisbursementBroadcast
+	if _, err := k.MongoDB.UpdateDisbursement(d); err != nil {
+		log.Println(err)
+	}
 
 	// Confirm that knstl transaction is done
-	t.DestinationNetworkHash = res.Hash.String()
+	t.DestinationNetworkHash = d.Hash
+	if t.Status == model.StatusDisbursing {
+		_ = updateTxStatus(k.MongoDB, t, model.StatusDisbursed, "broadcast "+t.DestinationNetworkHash)
+	}
+	txBytes, err := hex.DecodeString(d.RawTx)
+	if err != nil {
+		log.Println(err)
+		return
+	}
 	ok := false
 	transactionCheckTryCount := 0
+	deadline := time.Now().Add(util.KnstlConfirmTimeoutMinutes * time.Minute)
 	log.Println("Start knstl transaction confirmation check")
 	for !ok {
-		ok, err = k.IsTransactionSuccessful(res.Hash.String())
-		notFoundErrMsg := fmt.Sprintf("Error Message: Internal error, Error Data: tx (%s) not found", res.Hash.String())
-		if err != nil && !strings.Contains(err.Error(), notFoundErrMsg) {
-			log.Println("Knstl transaction sent has error: ", err)
-			k.updateTransactionComplete(t)
+		if time.Now().After(deadline) { // left in disbursed, picked up again by WatchDisbursements
+			log.Println("Knstl transaction", d.Hash, "is not confirmed before the deadline")
 			return
 		}
+		ok, err = k.IsTransactionSuccessful(d.Hash)
 		transactionCheckTryCount++
+		if ok {
+			break
+		}
+		if errors.Is(err, errTxLookup) {
+			log.Println(err)
+			time.Sleep(util.SleepTimeSeconds * time.Second)
+			continue
+		}
+		if !isTxNotFound(err) { // included but failed, lookup errors are retried above
+			log.Println("Knstl transaction sent has error: ", err)
+			k.failTransaction(t, "knstl transaction "+err.Error())
+			return
+		}
+		if _, lost, err := k.settleBroadcast(txBytes, d.Hash); err != nil {
+			log.Println(err)
+		} else if lost {
+			k.failTransaction(t, "sequence of "+d.Hash+" is used by another tx")
+			return
+		} else if _, err := k.broadcast(txBytes); err != nil { // dropped from the mempool
+			log.Println(err)
+		}
+		time.Sleep(util.SleepTimeSeconds * time.Second)
 	}
 	log.Println("Knstl transaction confirmation check total try: ", transactionCheckTryCount)
 	log.Println("Finished knstl transaction confirmation check")
 
-	log.Println("Complete knstl transaction:", res.Hash.String())
-	t.DestinationNetworkCompleted = true
+	log.Println("Complete knstl transaction:", d.Hash)
+	d.Status = model.DisbursementConfirmed
+	if _, err := k.MongoDB.UpdateDisbursement(d); err != nil {
+		log.Println(err)
+	}
+	_ = updateTxStatus(k.MongoDB, t, model.StatusConfirmed, "confirmed "+t.DestinationNetworkHash)
 	log.Println("Destination network hash:", t.DestinationNetworkHash, "Destination network completed", t.DestinationNetworkCompleted)
-	k.updateTransactionComplete(t)
 	log.Printf("transaction is updated in the DB: %+v\n", *t)
 
 	k.msgChan <- "###### Disbursing knstl funds for transaction: " + t.ID.String() + " ######"
 }
 
+// signPayout builds and signs the bank send paying out the swap
+func (k *KnstlConnection) signPayout(t *model.Tx) ([]byte, uint64, error) {
+	txAmount, err := decimal.NewFromString(t.Amount)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid amount %q: %v", t.Amount, err)
+	}
+	decimalAmount := util.GetTransactionAmount("knstl", txAmount)
+	log.Println("user transaction total knstl amount:", decimalAmount)
+	//decimalKnstlFee := util.GetTransactionAmount("knstl", decimal.RequireFromString(util.UserKnstlTransactionFee))
+	//log.Println("user transaction fee knstl amount:", decimalKnstlFee)
+	//totalAmountDecimal := decimalAmount.Sub(decimalKnstlFee)
+	//log.Println("Total decimal amount deducting fee:", totalAmountDecimal)
+	return k.signSend(t.ToAddress, decimalAmount.BigInt())
+}
+
+// signSend signs a send of amount base units from KNSTL_CORPORATE_ADDR with the next
+// account sequence. Returns the sequence, the caller releases it.
+func (k *KnstlConnection) signSend(toAddress string, amount *big.Int) ([]byte, uint64, error) {
+	toAddr, err := types.AccAddressFromBech32(toAddress)
+	if err != nil {
+		log.Println("Invalid corporate wallet:", toAddress, err)
+		return nil, 0, fmt.Errorf("invalid destination address: %v", err)
+	}
+	log.Println("Knstl toaddress", toAddress, "Knstl AccAddressFromBech with toaddress", toAddr)
+	corporateWallet, err := types.AccAddressFromBech32(k.swapAddr)
+	if err != nil {
+		log.Println("Invalid corporate wallet:", k.swapAddr, err)
+		return nil, 0, fmt.Errorf("invalid swap address: %v", err)
+	}
+	log.Println("Knstl swapAddr(KNSTL_CORPORATE_ADDR)", k.swapAddr, "Knstl AccAddressFromBech with swapAddr", corporateWallet)
+	msg := banktypes.NewMsgSend(corporateWallet, toAddr, types.NewCoins(types.NewCoin("udarc", types.NewIntFromBigInt(amount))))
+	err = msg.ValidateBasic()
+	if err != nil {
+		log.Printf("Invalid tx msg: %v", err)
+		return nil, 0, fmt.Errorf("invalid tx msg: %v", err)
+	}
+	log.Printf("Knstl tx msg: %+v\n", msg)
+	encCfg := simapp.MakeTestEncodingConfig()
+	accountNumber, sequence, err := k.sequences.Allocate()
+	if err != nil {
+		log.Printf("Failed to get account %s: %v", keyringInfo.GetAddress().String(), err)
+		return nil, 0, fmt.Errorf("failed to get account sequence: %v", err)
+	}
+	log.Println("Knstl account", accountNumber, "sequence", sequence)
+	txFactory := tx.Factory{}
+	txFactory = txFactory.
+		WithKeybase(cryptoKeyring).
+		WithTxConfig(encCfg.TxConfig).
+		WithAccountNumber(accountNumber).
+		WithSequence(sequence).
+		WithChainID(ChainID).
+		WithSignMode(signing.SignMode_SIGN_MODE_DIRECT).
+		WithGasAdjustment(k.gasAdjustment)
+	gas, err := k.simulateGas(txFactory, msg)
+	if err != nil {
+		log.Println("Simulating transaction is failed:", err)
+		k.sequences.Release(sequence, false)
+		return nil, 0, fmt.Errorf("gas simulation failed: %v", err)
+	}
+	txFactory = txFactory.WithGas(gas).WithGasPrices(k.gasPrices)
+	txBuilder, err := tx.BuildUnsignedTx(txFactory, msg)
+	if err != nil {
+		log.Println("Building transaction is failed")
+		k.sequences.Release(sequence, false)
+		return nil, 0, fmt.Errorf("failed to build tx: %v", err)
+	}
+	log.Printf("gas: %d\n", txBuilder.GetTx().GetGas())
+	log.Printf("fee: %+v\n", txBuilder.GetTx().GetFee())
+	if err := k.checkBalance(types.NewIntFromBigInt(amount).Add(txBuilder.GetTx().GetFee().AmountOf(Denom))); err != nil {
+		k.sequences.Release(sequence, false)
+		return nil, 0, err
+	}
+	if err := tx.Sign(txFactory, keyringInfo.GetName(), txBuilder, true); err != nil {
+		log.Println("Signing transaction is failed")
+		k.sequences.Release(sequence, false)
+		return nil, 0, fmt.Errorf("failed to sign: %v", err)
+	}
+	txBytes, err := encCfg.TxConfig.TxEncoder()(txBuilder.GetTx())
+	if err != nil {
+		log.Println("Encoding transaction is failed")
+		k.sequences.Release(sequence, false)
+		return nil, 0, fmt.Errorf("failed to encode: %v", err)
+	}
+	return txBytes, sequence, nil
+}
+
+// ResumeDisbursements picks up the knstl payouts interrupted by a restart
+func (k *KnstlConnection) ResumeDisbursements() {
+	resumeDisbursements(k.MongoDB, "knstl", k.DisburseFunds)
+}
+
+// WatchDisbursements re-runs the open knstl payouts every DisbursementRecheckMinutes
+func (k *KnstlConnection) WatchDisbursements() {
+	watchDisbursements(k.MongoDB, "knstl", k.DisburseFunds)
+}
+
 func (k *KnstlConnection) GetTx(hash string) (map[string]interface{}, error) {
 	txHash := common.HexToHash(hash)
-	knstlTransactionCheckUrl := k.knstlUrl + "/tx?hash=" + txHash.String()
-	log.Println("knstlTxUrl:", knstlTransactionCheckUrl)
+	rpcUrl := k.rpcEndpoints.Active()
+	knstlTransactionCheckUrl := rpcUrl + "/tx?hash=" + txHash.String()
+	log.Println("knstlTxUrl:", redactURL(rpcUrl)+"/tx?hash="+txHash.String())
 	tx, err := http.Get(knstlTransactionCheckUrl)
 	if err != nil {
 		log.Println(err)
@@ -325,6 +1005,174 @@ func (k *KnstlConnection) GetTx(hash string) (map[string]interface{}, error) {
 	return txResult, nil
 }
 
+// broadcast sends the tx to the mempool of the active rpc endpoint
+func (k *KnstlConnection) broadcast(txBytes []byte) (*tendermintrpctypes.ResultBroadcastTx, error) {
+	res, err := k.rpc().BroadcastTxSync(context.Background(), txBytes)
+	if err == nil && res.Code != 0 && res.Code != sdkerrors.ErrTxInMempoolCache.ABCICode() {
+		err = fmt.Errorf("check tx failed with code %d: %s", res.Code, res.Log)
+	}
+	return res, err
+}
+
+// signAndBroadcast broadcasts the tx built by sign, recording every signed tx before it is
+// broadcast. A tx rejected for its account sequence is broadcast again once the lower
+// sequences in flight reach the mempool, or signed again with the sequence the chain expects.
+func (k *KnstlConnection) signAndBroadcast(sign func() ([]byte, uint64, error), record func([]byte) error) (*tendermintrpctypes.ResultBroadcastTx, error) {
+	txBytes, sequence, err := sign()
+	if err != nil {
+		return nil, err
+	}
+	if err := record(txBytes); err != nil {
+		k.sequences.Release(sequence, false)
+		return nil, err
+	}
+	for attempt := 1; ; attempt++ {
+		res, err := k.broadcast(txBytes)
+		if err == nil {
+			k.sequences.Release(sequence, true)
+			return res, nil
+		}
+		// the recorded tx may still reach the chain, its sequence stays in flight until it is settled
+		if res == nil || res.Code != sdkerrors.ErrWrongSequence.ABCICode() || attempt >= util.KnstlSequenceRetries {
+			return nil, fmt.Errorf("%w: %v", errBroadcastPending, err)
+		}
+		expected, got, parseErr := parseSequenceMismatch(res.Log)
+		if parseErr != nil || got != sequence {
+			return nil, fmt.Errorf("%w: %v", errBroadcastPending, err)
+		}
+		if !k.sequences.Mismatch(sequence, expected) {
+			log.Println("Knstl: sequence", sequence, "waits for the lower sequences in flight")
+			time.Sleep(util.KnstlSequenceRetrySeconds * time.Second)
+			continue
+		}
+		if txBytes, sequence, err = sign(); err != nil {
+			return nil, err
+		}
+		if err := record(txBytes); err != nil {
+			k.sequences.Release(sequence, false)
+			return nil, err
+		}
+	}
+}
+
+// settleBroadcast resolves a recorded tx whose broadcast failed. included reports that it is in
+// a block, lost that the chain used its sequence for another tx so it can never be included.
+// The sequence is released once either is known.
+func (k *KnstlConnection) settleBroadcast(txBytes []byte, hash string) (included bool, lost bool, err error) {
+	sequence, err := txSequence(txBytes)
+	if err != nil {
+		return false, false, err
+	}
+	acc, err := k.swapAccount() // read before the tx so an inclusion in between is not taken as lost
+	if err != nil {
+		return false, false, err
+	}
+	if acc.Sequence <= sequence {
+		return false, false, nil
+	}
+	_, err = k.IsTransactionSuccessful(hash)
+	if errors.Is(err, errTxLookup) {
+		return false, false, err
+	}
+	k.sequences.Release(sequence, true)
+	if isTxNotFound(err) {
+		log.Println("Knstl: sequence", sequence, "of", hash, "is used by another tx")
+		return false, true, nil
+	}
+	return true, false, nil // a failed tx is included too
+}
+
+// releaseSequence ends the allocation of the sequence of a broadcast tx
+func (k *KnstlConnection) releaseSequence(txBytes []byte) {
+	sequence, err := txSequence(txBytes)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	k.sequences.Release(sequence, true)
+}
+
+// txSequence returns the account sequence a tx is signed with
+func txSequence(txBytes []byte) (uint64, error) {
+	decoded, err := knstlTxDecoder(txBytes)
+	if err != nil {
+		return 0, err
+	}
+	sigTx, ok := decoded.(authsigning.SigVerifiableTx)
+	if !ok {
+		return 0, fmt.Errorf("knstl tx carries no signatures")
+	}
+	sigs, err := sigTx.GetSignaturesV2()
+	if err != nil {
+		return 0, err
+	}
+	if len(sigs) == 0 {
+		return 0, fmt.Errorf("knstl tx carries no signatures")
+	}
+	return sigs[0].Sequence, nil
+}
+
+// isTxNotFound reports whether the node does not know the tx
+func isTxNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "not found")
+}
+
+// swapAccount reads the swap account
+func (k *KnstlConnection) swapAccount() (*authtypes.BaseAccount, error) {
+	ctx, cancel := grpcCallContext()
+	defer cancel()
+	return getAccount(ctx, k.grpcConn.Conn(), keyringInfo.GetAddress().String())
+}
+
+// simulateGas returns the gas used by the tx in a simulation, times the gas adjustment.
+// Sequences still in flight are not in the simulated state yet, so a simulation rejected
+// for its sequence runs again with the sequence the chain expects.
+func (k *KnstlConnection) simulateGas(txf tx.Factory, msg types.Msg) (uint64, error) {
+	gas, err := k.simulate(txf, msg)
+	if err != nil {
+		expected, _, parseErr := parseSequenceMismatch(err.Error())
+		if parseErr != nil {
+			return 0, err
+		}
+		if gas, err = k.simulate(txf.WithSequence(expected), msg); err != nil {
+			return 0, err
+		}
+	}
+	log.Println("Knstl simulated gas with adjustment:", gas)
+	return gas, nil
+}
+
+func (k *KnstlConnection) simulate(txf tx.Factory, msg types.Msg) (uint64, error) {
+	txBytes, err := tx.BuildSimTx(txf, msg)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := grpcCallContext()
+	defer cancel()
+	res, err := txtypes.NewServiceClient(k.grpcConn.Conn()).Simulate(ctx, &txtypes.SimulateRequest{TxBytes: txBytes})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(txf.GasAdjustment() * float64(res.GasInfo.GasUsed)), nil
+}
+
+// checkBalance fails when the swap account holds less than the amount
+func (k *KnstlConnection) checkBalance(amount types.Int) error {
+	ctx, cancel := grpcCallContext()
+	defer cancel()
+	res, err := banktypes.NewQueryClient(k.grpcConn.Conn()).Balance(ctx, &banktypes.QueryBalanceRequest{
+		Address: keyringInfo.GetAddress().String(),
+		Denom:   Denom,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get balance: %v", err)
+	}
+	if res.Balance == nil || res.Balance.Amount.LT(amount) {
+		return fmt.Errorf("swap account balance %v is below %s%s", res.Balance, amount, Denom)
+	}
+	return nil
+}
+
 func getAccount(ctx context.Context, conn *grpc.ClientConn, addr string) (*authtypes.BaseAccount, error) {
 	authClient := authtypes.NewQueryClient(conn)
 	r := &authtypes.QueryAccountRequest{Address: addr}
@@ -341,37 +1189,38 @@ func getAccount(ctx context.Context, conn *grpc.ClientConn, addr string) (*autht
 	return bacc, nil
 }
 
-func (k *KnstlConnection) updateTransactionComplete(tx *model.Tx) {
-	tx.Completed = true
-	tx.UpdatedAt = time.Now().Format(util.TimeFormat)
-	_, err := k.MongoDB.UpdateTx(tx)
-	if err != nil {
-		log.Println(err)
+func (k *KnstlConnection) failTransaction(tx *model.Tx, reason string) {
+	if err := updateTxStatus(k.MongoDB, tx, model.StatusFailed, reason); err != nil {
 		return
 	}
+	refundFailedSwap(k.MongoDB, tx, reason)
 }
 
 func (k *KnstlConnection) IsTransactionSuccessful(hash string) (bool, error) {
 	knstlResult, err := k.GetTx(hash)
 	if err != nil {
-		err := fmt.Errorf("Knstl transaction " + err.Error())
-		return false, err
+		return false, fmt.Errorf("%w: %v", errTxLookup, err)
 	}
 	log.Println("KnstlResult:", knstlResult)
 	if _, ok := knstlResult["error"]; ok {
 		txErr, _ := knstlResult["error"].(map[string]interface{})
 		errMsg, _ := txErr["message"].(string)
 		errData, _ := txErr["data"].(string)
-		err := fmt.Errorf("Error Message: %s, Error Data: %s\n", errMsg, errData)
-		return false, err
-	} else {
-		result, _ := knstlResult["result"].(map[string]interface{})
-		txResult, _ := result["tx_result"].(map[string]interface{})
-		logStr, _ := txResult["log"].(string)
-		if !strings.Contains(logStr, "events") {
-			err := fmt.Errorf(logStr)
+		err := fmt.Errorf("Error Message: %s, Error Data: %s", errMsg, errData)
+		if isTxNotFound(err) {
 			return false, err
 		}
+		// indexing disabled, pruned heights or a failing node say nothing about the tx
+		return false, fmt.Errorf("%w: %v", errTxLookup, err)
+	}
+	result, ok := knstlResult["result"].(map[string]interface{})
+	if !ok {
+		return false, fmt.Errorf("%w: unexpected response %v", errTxLookup, knstlResult)
+	}
+	txResult, _ := result["tx_result"].(map[string]interface{})
+	logStr, _ := txResult["log"].(string)
+	if code, _ := txResult["code"].(float64); code != 0 { // included but failed
+		return false, fmt.Errorf("failed with code %v: %s", code, logStr)
 	}
 	return true, nil
 }
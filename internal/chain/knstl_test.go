@@ -0,0 +1,134 @@
+package chain
+
+import (
+	"math/big"
+	"testing"
+
+	abcitypes "github.com/tendermint/tendermint/abci/types"
+)
+
+const (
+	testSwapAddr = "darc1swap"
+	testUser     = "darc1user"
+	testOther    = "darc1other"
+	testGrantee  = "darc1grantee"
+)
+
+func testEvent(typ string, kv ...string) abcitypes.Event {
+	event := abcitypes.Event{Type: typ}
+	for i := 0; i+1 < len(kv); i += 2 {
+		event.Attributes = append(event.Attributes, abcitypes.EventAttribute{Key: []byte(kv[i]), Value: []byte(kv[i+1])})
+	}
+	return event
+}
+
+func TestDeposits(t *testing.T) {
+	type want struct {
+		sender string
+		amount int64 // 0 when the transfer is recorded without a deposit
+	}
+	tests := []struct {
+		name   string
+		events []abcitypes.Event
+		want   []want
+	}{
+		{
+			name: "bank send",
+			events: []abcitypes.Event{
+				testEvent("message", "action", "send", "sender", testUser, "module", "bank"),
+				testEvent("transfer", "recipient", testSwapAddr, "sender", testUser, "amount", "1000udarc"),
+			},
+			want: []want{{testUser, 1000}},
+		},
+		{
+			name: "bank send to another address",
+			events: []abcitypes.Event{
+				testEvent("message", "action", "send", "sender", testUser),
+				testEvent("transfer", "recipient", testOther, "sender", testUser, "amount", "1000udarc"),
+			},
+		},
+		{
+			name: "bank send of other denoms",
+			events: []abcitypes.Event{
+				testEvent("message", "action", "send", "sender", testUser),
+				testEvent("transfer", "recipient", testSwapAddr, "sender", testUser, "amount", "1000uatom"),
+			},
+			want: []want{{"", 0}},
+		},
+		{
+			name: "bank send of several denoms",
+			events: []abcitypes.Event{
+				testEvent("message", "action", "send", "sender", testUser),
+				testEvent("transfer", "recipient", testSwapAddr, "sender", testUser, "amount", "5uatom,250udarc"),
+			},
+			want: []want{{testUser, 250}},
+		},
+		{
+			name: "invalid amount",
+			events: []abcitypes.Event{
+				testEvent("message", "action", "send", "sender", testUser),
+				testEvent("transfer", "recipient", testSwapAddr, "sender", testUser, "amount", "udarc"),
+			},
+			want: []want{{"", 0}},
+		},
+		{
+			name: "multisend outputs to the swap address",
+			events: []abcitypes.Event{
+				testEvent("message", "action", "multisend", "sender", testUser, "module", "bank"),
+				testEvent("transfer", "recipient", testOther, "amount", "5udarc"),
+				testEvent("transfer", "recipient", testSwapAddr, "amount", "10udarc"),
+				testEvent("transfer", "recipient", testSwapAddr, "amount", "20udarc"),
+			},
+			want: []want{{testUser, 10}, {testUser, 20}},
+		},
+		{
+			name: "multisend with several inputs",
+			events: []abcitypes.Event{
+				testEvent("message", "action", "multisend", "sender", testUser, "sender", testOther),
+				testEvent("transfer", "recipient", testSwapAddr, "amount", "10udarc"),
+			},
+			want: []want{{"", 0}},
+		},
+		{
+			name: "authz exec of a bank send",
+			events: []abcitypes.Event{
+				testEvent("message", "action", "/cosmos.authz.v1beta1.MsgExec", "sender", testGrantee),
+				testEvent("message", "sender", testUser, "module", "bank"),
+				testEvent("transfer", "recipient", testSwapAddr, "sender", testUser, "amount", "300udarc"),
+			},
+			want: []want{{testUser, 300}},
+		},
+		{
+			name: "no transfer",
+			events: []abcitypes.Event{
+				testEvent("message", "action", "vote", "sender", testUser),
+			},
+		},
+	}
+	k := &KnstlConnection{swapAddr: testSwapAddr}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := k.deposits("HASH", 12, "memo", tt.events)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d deposits, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, d := range got {
+				if d.Hash != "HASH" || d.Height != 12 || d.Memo != "memo" || d.Index != i {
+					t.Errorf("deposit %d: got %+v", i, d)
+				}
+				if d.Sender != tt.want[i].sender {
+					t.Errorf("deposit %d: sender %q, want %q", i, d.Sender, tt.want[i].sender)
+				}
+				if tt.want[i].amount == 0 {
+					if d.Amount != nil {
+						t.Errorf("deposit %d: amount %s, want none", i, d.Amount)
+					}
+					continue
+				}
+				if d.Amount == nil || d.Amount.Cmp(big.NewInt(tt.want[i].amount)) != 0 {
+					t.Errorf("deposit %d: amount %v, want %d", i, d.Amount, tt.want[i].amount)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package chain
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/konstellation/swap/internal/model"
+	"github.com/konstellation/swap/internal/mongo"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+)
+
+// nonceManager hands out the nonces of one signing address. Allocations are
+// serialized and persisted so concurrent payouts never share a nonce.
+type nonceManager struct {
+	mu       sync.Mutex
+	ctx      context.Context
+	client   func() *ethclient.Client // client of the active endpoint
+	mg       *mongo.Connection
+	address  common.Address
+	inFlight map[uint64]bool
+}
+
+func newNonceManager(ctx context.Context, client func() *ethclient.Client, mg *mongo.Connection, address common.Address) *nonceManager {
+	return &nonceManager{
+		ctx:      ctx,
+		client:   client,
+		mg:       mg,
+		address:  address,
+		inFlight: make(map[uint64]bool),
+	}
+}
+
+// Allocate returns the next nonce. The stored nonce is resynced to the floor, the
+// mined nonce or one past the highest nonce with a stored signed tx, when it fell
+// behind it, or when it is ahead of it while nothing is in flight, which means
+// allocated nonces never reached the chain. The pending nonce of a single endpoint
+// is only trusted for the first allocation.
+func (m *nonceManager) Allocate() (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mined, err := m.client().NonceAt(m.ctx, m.address, nil)
+	if err != nil {
+		return 0, err
+	}
+	stored, err := m.mg.GetNonce(m.address.Hex())
+	if err == mongodrv.ErrNoDocuments {
+		pending, err := m.client().PendingNonceAt(m.ctx, m.address)
+		if err != nil {
+			return 0, err
+		}
+		stored = &model.Nonce{ID: m.address.Hex(), Next: maxNonce(pending, mined)}
+	} else if err != nil {
+		return 0, err
+	}
+
+	floor := maxNonce(mined, stored.Signed)
+	switch {
+	case stored.Next < floor:
+		log.Println("BSC: nonce", stored.Next, "is behind the chain, resync to", floor)
+		stored.Next = floor
+	case stored.Next > floor && len(m.inFlight) == 0:
+		log.Println("BSC: nonce gap from", floor, "to", stored.Next, ", resync to", floor)
+		stored.Next = floor
+	}
+
+	nonce := stored.Next
+	stored.Next++
+	if err := m.mg.SaveNonce(stored); err != nil {
+		return 0, err
+	}
+	m.inFlight[nonce] = true
+	return nonce, nil
+}
+
+// Release ends the allocation. A broadcast nonce has its signed tx stored and
+// raises the resync floor. A nonce that was never broadcast is handed back when
+// it is the last one allocated, otherwise it is left to the gap resync.
+func (m *nonceManager) Release(nonce uint64, broadcast bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.inFlight, nonce)
+	stored, err := m.mg.GetNonce(m.address.Hex())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if broadcast {
+		if stored.Signed < nonce+1 {
+			stored.Signed = nonce + 1
+			if err := m.mg.SaveNonce(stored); err != nil {
+				log.Println(err)
+			}
+		}
+		return
+	}
+	if stored.Next == nonce+1 {
+		stored.Next = nonce
+		if err := m.mg.SaveNonce(stored); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func maxNonce(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
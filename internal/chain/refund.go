@@ -0,0 +1,474 @@
+package chain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cosmos/cosmos-sdk/types"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/konstellation/swap/internal/model"
+	"github.com/konstellation/swap/internal/mongo"
+	"github.com/konstellation/swap/internal/util"
+	"github.com/shopspring/decimal"
+	tmtypes "github.com/tendermint/tendermint/types"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+)
+
+// recordRefund keeps an orphaned deposit waiting for an operator to approve its refund.
+// The swap the deposit was made for, when there is one, tracks the refund status.
+// Other deposits at the address of a swap are tracked on the refund only.
+func recordRefund(mg *mongo.Connection, r *model.Refund, tx *model.Tx) {
+	if tx != nil {
+		r.TxID = tx.ID
+	}
+	stored, err := mg.RecordRefund(r)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Printf("Refund %s of %s deposit %s (%s): %s\n", stored.ID.Hex(), stored.Network, stored.DepositHash, stored.Reason, stored.Status)
+	if tx != nil && tx.RefundStatus == "" {
+		tx.RefundStatus = stored.Status
+		if _, err := mg.UpdateTx(tx); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// refundFailedSwap records a refund of the deposit of a swap that failed after the deposit
+// was confirmed. Swaps confirmed before the sender was stored are refunded to the requester.
+func refundFailedSwap(mg *mongo.Connection, tx *model.Tx, reason string) {
+	if !tx.SourceNetworkCompleted || tx.SourceNetworkHash == "" {
+		return
+	}
+	r := model.NewRefund(tx.SourceNetwork, tx.SourceNetworkHash, tx.SourceNetworkIndex)
+	r.Sender = tx.SourceSender
+	if r.Sender == "" {
+		r.Sender = tx.FromAddress
+	}
+	r.Amount = tx.Amount
+	r.Fee = util.UserBscTransactionFee
+	if tx.SourceNetwork == "knstl" {
+		r.Fee = util.UserKnstlTransactionFee
+	}
+	r.Reason = "swap failed: " + reason
+	recordRefund(mg, r, tx)
+}
+
+// refundAmount is the amount sent back, the deposit minus the refund fee
+func refundAmount(r *model.Refund) (decimal.Decimal, error) {
+	amount, err := decimal.NewFromString(r.Amount)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid amount %q: %v", r.Amount, err)
+	}
+	fee, err := decimal.NewFromString(r.Fee)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid fee %q: %v", r.Fee, err)
+	}
+	if !amount.GreaterThan(fee) {
+		return decimal.Zero, fmt.Errorf("deposit of %s does not cover the refund fee %s", amount, fee)
+	}
+	return amount.Sub(fee), nil
+}
+
+// updateRefund stores the refund and mirrors its status and hash on the swap of the deposit.
+// A confirmed refund moves the swap to refunded.
+func updateRefund(mg *mongo.Connection, r *model.Refund) {
+	if _, err := mg.UpdateRefund(r); err != nil {
+		log.Println(err)
+	}
+	if r.TxID.IsZero() {
+		return
+	}
+	result, err := mg.GetTx(r.TxID)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	tx, _ := result.(model.Tx)
+	tx.RefundStatus = r.Status
+	tx.RefundHash = r.Hash
+	if r.Status == model.RefundConfirmed && tx.Status.CanTransitionTo(model.StatusRefunded) {
+		_ = updateTxStatus(mg, &tx, model.StatusRefunded, "refunded "+r.Hash)
+		return
+	}
+	if _, err := mg.UpdateTx(&tx); err != nil {
+		log.Println(err)
+	}
+}
+
+// failRefund gives up a refund, the operator has to settle it by hand
+func failRefund(mg *mongo.Connection, r *model.Refund, reason string) {
+	log.Println("Refund", r.ID.Hex(), "failed:", reason)
+	r.Status = model.RefundFailed
+	r.LastError = reason
+	updateRefund(mg, r)
+}
+
+// processRefunds advances the approved refunds of the network every RefundPollSeconds
+func processRefunds(mg *mongo.Connection, network string, refund func(*model.Refund) error) {
+	for _, status := range []string{model.RefundApproved, model.RefundSigned, model.RefundBroadcast} {
+		filter := map[string]string{
+			"network": network,
+			"status":  status,
+		}
+		refunds, err := mg.FindRefunds(filter)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for i := range refunds {
+			if err := refund(&refunds[i]); err != nil {
+				log.Println("Failed to refund", refunds[i].ID.Hex(), err)
+				refunds[i].LastError = err.Error()
+				if _, err := mg.UpdateRefund(&refunds[i]); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}
+}
+
+// ProcessRefunds records the orphaned bsc deposits and sends the approved bsc refunds
+func (b *BSCConnection) ProcessRefunds() {
+	for {
+		if err := b.collectOrphanDeposits(); err != nil {
+			log.Println(err)
+		}
+		processRefunds(b.MongoDB, "bsc", b.refund)
+		time.Sleep(util.RefundPollSeconds * time.Second)
+	}
+}
+
+// collectOrphanDeposits records a refund for every stored deposit older than BscOrphanBlocks
+// that no open swap can match anymore, and takes it out of the deposits waiting for a swap.
+// Deposits referenced by the source hash of a swap are never refunded.
+func (b *BSCConnection) collectOrphanDeposits() error {
+	head, err := b.node().BlockNumber(b.ctx)
+	if err != nil {
+		return err
+	}
+	filter := map[string]string{
+		"source_network": "bsc",
+		"completed":      "false",
+	}
+	open, err := b.MongoDB.FindTxs(filter)
+	if err != nil {
+		return err
+	}
+	senders := make(map[common.Address]bool)
+	depositAddresses := make(map[common.Address]bool)
+	for _, tx := range open {
+		if tx.DepositAddress != "" {
+			depositAddresses[common.HexToAddress(tx.DepositAddress)] = true
+		} else {
+			senders[common.HexToAddress(tx.FromAddress)] = true
+		}
+	}
+
+	cur, err := b.MongoDB.FindBscTx(map[string]string{"removed": "false"})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(b.MongoDB.Ctx)
+	var orphans []ethtypes.Log
+	for cur.Next(b.MongoDB.Ctx) {
+		vLog := ethtypes.Log{}
+		if err := cur.Decode(&vLog); err != nil {
+			return err
+		}
+		if !isTransferLog(&vLog) || head < vLog.BlockNumber+util.BscOrphanBlocks {
+			continue
+		}
+		from := common.BytesToAddress(vLog.Topics[1].Bytes())
+		to := common.BytesToAddress(vLog.Topics[2].Bytes())
+		if b.isDepositAddress(to) {
+			if depositAddresses[to] {
+				continue
+			}
+		} else if senders[from] || from == crypto.PubkeyToAddress(*b.pubKey) {
+			continue
+		}
+		orphans = append(orphans, vLog)
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	for i := range orphans {
+		vLog := &orphans[i]
+		paid, err := b.MongoDB.FindTx(map[string]string{
+			"source_network":      "bsc",
+			"source_network_hash": vLog.TxHash.String(),
+		})
+		if err != nil && err != mongodrv.ErrNoDocuments {
+			return err
+		}
+		if tx, ok := paid.(model.Tx); ok { // the deposit of a swap whose payout was interrupted
+			log.Println("BSC: deposit", vLog.TxHash.String(), "belongs to swap", tx.ID.Hex())
+			if err := b.MongoDB.ConsumeBscTx(vLog, tx.ID); err != nil {
+				return err
+			}
+			continue
+		}
+		to := common.BytesToAddress(vLog.Topics[2].Bytes())
+		r := model.NewRefund("bsc", vLog.TxHash.String(), int(vLog.Index))
+		r.Sender = common.BytesToAddress(vLog.Topics[1].Bytes()).Hex()
+		r.Amount = b.getAmount(vLog.Data).String()
+		r.Fee = util.UserBscTransactionFee
+		r.Reason = "no matching swap request"
+		if b.isDepositAddress(to) { // the address belongs to a swap that is over
+			result, err := b.MongoDB.FindTx(map[string]string{"deposit_address": to.Hex()})
+			if err != nil && err != mongodrv.ErrNoDocuments {
+				return err
+			}
+			if tx, ok := result.(model.Tx); ok { // not the deposit of the swap, only the refund tracks it
+				r.Reason = "deposit after the swap " + tx.ID.Hex() + " is " + tx.Status.String()
+			}
+		}
+		recordRefund(b.MongoDB, r, nil)
+		vLog.Removed = true
+		if _, err := b.MongoDB.UpdateBscTx(vLog); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refund advances an approved bsc refund by a step: sign, broadcast, then confirm
+func (b *BSCConnection) refund(r *model.Refund) error {
+	switch r.Status {
+	case model.RefundApproved:
+		amount, err := refundAmount(r)
+		if err != nil {
+			failRefund(b.MongoDB, r, err.Error())
+			return nil
+		}
+		if !common.IsHexAddress(r.Sender) {
+			failRefund(b.MongoDB, r, "invalid sender address "+r.Sender)
+			return nil
+		}
+		signedTx, err := b.signTokenTransfer(common.HexToAddress(r.Sender), util.GetTransactionAmount("bsc", amount).BigInt())
+		if err != nil {
+			return err
+		}
+		raw, err := signedTx.MarshalBinary()
+		if err != nil {
+			b.nonces.Release(signedTx.Nonce(), false)
+			return err
+		}
+		r.RawTx = hexutil.Encode(raw)
+		r.Hash = signedTx.Hash().Hex()
+		r.Status = model.RefundSigned
+		if _, err := b.MongoDB.UpdateRefund(r); err != nil {
+			b.nonces.Release(signedTx.Nonce(), false)
+			r.RawTx, r.Hash, r.Status = "", "", model.RefundApproved // never sent, signed again on the next run
+			return err
+		}
+		log.Println("BSC: refunding", amount, "to", r.Sender, "in", r.Hash)
+		err = b.node().SendTransaction(b.ctx, signedTx)
+		// a failed send may still reach a mempool, the nonce stays taken and the refund signed
+		b.nonces.Release(signedTx.Nonce(), true)
+		if err != nil {
+			updateRefund(b.MongoDB, r)
+			return err
+		}
+		r.Status = model.RefundBroadcast
+		updateRefund(b.MongoDB, r)
+		return nil
+
+	case model.RefundSigned: // never build a second refund while the signed one can be mined
+		if err := b.sendRawRefund(r); err != nil && !isKnownBscTx(err) {
+			if resigned, checkErr := b.resignTakenRefund(r); checkErr != nil || !resigned {
+				return err
+			}
+			return nil
+		}
+		r.Status = model.RefundBroadcast
+		updateRefund(b.MongoDB, r)
+		return nil
+
+	case model.RefundBroadcast:
+		receipt, err := b.node().TransactionReceipt(b.ctx, common.HexToHash(r.Hash))
+		if err == ethereum.NotFound {
+			if _, _, err := b.node().TransactionByHash(b.ctx, common.HexToHash(r.Hash)); err != ethereum.NotFound {
+				return err // still pending
+			}
+			if resigned, err := b.resignTakenRefund(r); err != nil || resigned {
+				return err
+			}
+			log.Println("BSC: refund", r.Hash, "is dropped, re-broadcasting")
+			if err := b.sendRawRefund(r); err != nil && !isKnownBscTx(err) {
+				return err
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if receipt.Status != ethtypes.ReceiptStatusSuccessful {
+			failRefund(b.MongoDB, r, fmt.Sprintf("reverted in block %s", receipt.BlockNumber))
+			return nil
+		}
+		head, err := b.node().BlockNumber(b.ctx)
+		if err != nil {
+			return err
+		}
+		if head+1 < receipt.BlockNumber.Uint64()+b.confirmations {
+			return nil
+		}
+		log.Println("BSC: refund", r.Hash, "is confirmed")
+		r.Status = model.RefundConfirmed
+		r.LastError = ""
+		updateRefund(b.MongoDB, r)
+	}
+	return nil
+}
+
+// sendRawRefund broadcasts the recorded signed refund
+func (b *BSCConnection) sendRawRefund(r *model.Refund) error {
+	signedTx, err := decodeRawTx(r.RawTx)
+	if err != nil {
+		return err
+	}
+	return b.node().SendTransaction(b.ctx, signedTx)
+}
+
+// resignTakenRefund moves the refund back to approved, to be signed again, once the chain
+// used the nonce of its signed tx for another tx. Only then the signed refund can never be mined.
+func (b *BSCConnection) resignTakenRefund(r *model.Refund) (bool, error) {
+	signedTx, err := decodeRawTx(r.RawTx)
+	if err != nil {
+		return false, err
+	}
+	taken, err := b.nonceTaken(signedTx.Nonce(), []common.Hash{signedTx.Hash()})
+	if err != nil || !taken {
+		return false, err
+	}
+	log.Println("BSC: nonce", signedTx.Nonce(), "of refund", r.Hash, "is used by another tx, signing again")
+	r.RawTx, r.Hash, r.Status = "", "", model.RefundApproved
+	updateRefund(b.MongoDB, r)
+	return true, nil
+}
+
+// ProcessRefunds sends the approved knstl refunds
+func (k *KnstlConnection) ProcessRefunds() {
+	for {
+		processRefunds(k.MongoDB, "knstl", k.refund)
+		time.Sleep(util.RefundPollSeconds * time.Second)
+	}
+}
+
+// refund advances an approved knstl refund by a step: sign, broadcast, then confirm
+func (k *KnstlConnection) refund(r *model.Refund) error {
+	switch r.Status {
+	case model.RefundApproved:
+		amount, err := refundAmount(r)
+		if err != nil {
+			failRefund(k.MongoDB, r, err.Error())
+			return nil
+		}
+		if _, err := types.AccAddressFromBech32(r.Sender); err != nil {
+			failRefund(k.MongoDB, r, "invalid sender address "+r.Sender)
+			return nil
+		}
+		log.Println("Knstl: refunding", amount, "to", r.Sender)
+		sign := func() ([]byte, uint64, error) {
+			return k.signSend(r.Sender, util.GetTransactionAmount("knstl", amount).BigInt())
+		}
+		record := func(txBytes []byte) error {
+			r.RawTx = hex.EncodeToString(txBytes)
+			r.Hash = fmt.Sprintf("%X", tmtypes.Tx(txBytes).Hash())
+			r.Status = model.RefundSigned
+			_, err := k.MongoDB.UpdateRefund(r)
+			return err
+		}
+		if _, err := k.signAndBroadcast(sign, record); err != nil {
+			if errors.Is(err, errBroadcastPending) { // kept signed, broadcast again on the next run
+				updateRefund(k.MongoDB, r)
+			}
+			return err
+		}
+		r.Status = model.RefundBroadcast
+		updateRefund(k.MongoDB, r)
+		return nil
+
+	case model.RefundSigned: // never build a second refund while the signed one can be included
+		return k.rebroadcastRefund(r)
+
+	case model.RefundBroadcast:
+		ok, err := k.IsTransactionSuccessful(r.Hash)
+		if errors.Is(err, errTxLookup) {
+			return err
+		}
+		if isTxNotFound(err) { // not in a block yet, or dropped from the mempool
+			txBytes, err := hex.DecodeString(r.RawTx)
+			if err != nil {
+				return err
+			}
+			included, lost, err := k.settleBroadcast(txBytes, r.Hash)
+			switch {
+			case err != nil:
+				return err
+			case lost:
+				k.resignRefund(r)
+				return nil
+			case included:
+				return nil
+			}
+			_, err = k.broadcast(txBytes)
+			return err
+		}
+		if err != nil {
+			failRefund(k.MongoDB, r, "knstl transaction "+err.Error())
+			return nil
+		}
+		if ok {
+			log.Println("Knstl: refund", r.Hash, "is confirmed")
+			r.Status = model.RefundConfirmed
+			r.LastError = ""
+			updateRefund(k.MongoDB, r)
+		}
+	}
+	return nil
+}
+
+// rebroadcastRefund broadcasts the recorded signed refund again. It is signed again
+// only once the chain used its sequence for another tx.
+func (k *KnstlConnection) rebroadcastRefund(r *model.Refund) error {
+	txBytes, err := hex.DecodeString(r.RawTx)
+	if err != nil {
+		return err
+	}
+	if _, err := k.broadcast(txBytes); err != nil { // the first broadcast may already be in a block
+		included, lost, settleErr := k.settleBroadcast(txBytes, r.Hash)
+		switch {
+		case settleErr != nil:
+			return settleErr
+		case lost:
+			k.resignRefund(r)
+			return nil
+		case !included:
+			return err
+		}
+	} else {
+		k.releaseSequence(txBytes)
+	}
+	r.Status = model.RefundBroadcast
+	updateRefund(k.MongoDB, r)
+	return nil
+}
+
+// resignRefund moves a refund whose signed tx can never be included back to approved
+func (k *KnstlConnection) resignRefund(r *model.Refund) {
+	log.Println("Knstl: sequence of refund", r.Hash, "is used by another tx, signing again")
+	r.RawTx, r.Hash, r.Status = "", "", model.RefundApproved
+	updateRefund(k.MongoDB, r)
+}
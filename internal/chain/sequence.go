@@ -0,0 +1,149 @@
+package chain
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	"github.com/konstellation/swap/internal/model"
+	"github.com/konstellation/swap/internal/mongo"
+	mongodrv "go.mongodb.org/mongo-driver/mongo"
+)
+
+// sequenceMismatchRe matches the check tx log of a tx signed with a wrong account sequence
+var sequenceMismatchRe = regexp.MustCompile(`account sequence mismatch, expected (\d+), got (\d+)`)
+
+// sequenceManager hands out the account sequences of the knstl swap account. The account
+// number and the next sequence are cached and the sequence is persisted, so payouts signed
+// back to back never share a sequence.
+type sequenceManager struct {
+	mu            sync.Mutex
+	account       func() (*authtypes.BaseAccount, error) // account of the active endpoint
+	mg            *mongo.Connection
+	address       string
+	loaded        bool
+	accountNumber uint64
+	next          uint64
+	inFlight      map[uint64]bool
+}
+
+func newSequenceManager(account func() (*authtypes.BaseAccount, error), mg *mongo.Connection, address string) *sequenceManager {
+	return &sequenceManager{
+		account:  account,
+		mg:       mg,
+		address:  address,
+		inFlight: make(map[uint64]bool),
+	}
+}
+
+// load reads the account from the chain and the stored sequence, the later one wins.
+// The lock must be held.
+func (m *sequenceManager) load() error {
+	acc, err := m.account()
+	if err != nil {
+		return err
+	}
+	stored, err := m.mg.GetNonce(m.address)
+	if err == mongodrv.ErrNoDocuments {
+		stored = &model.Nonce{ID: m.address}
+	} else if err != nil {
+		return err
+	}
+	m.accountNumber = acc.AccountNumber
+	m.next = stored.Next
+	if m.next < acc.Sequence {
+		log.Println("Knstl: sequence", m.next, "is behind the chain, resync to", acc.Sequence)
+		m.next = acc.Sequence
+	}
+	m.loaded = true
+	return nil
+}
+
+// save persists the next sequence. The lock must be held.
+func (m *sequenceManager) save() error {
+	return m.mg.SaveNonce(&model.Nonce{ID: m.address, Next: m.next})
+}
+
+// Allocate returns the account number and the next sequence
+func (m *sequenceManager) Allocate() (uint64, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.loaded {
+		if err := m.load(); err != nil {
+			return 0, 0, err
+		}
+	}
+	sequence := m.next
+	m.next++
+	if err := m.save(); err != nil {
+		m.next--
+		return 0, 0, err
+	}
+	m.inFlight[sequence] = true
+	return m.accountNumber, sequence, nil
+}
+
+// Release ends the allocation. A sequence that was never broadcast is handed back
+// when it is the last one allocated.
+func (m *sequenceManager) Release(sequence uint64, broadcast bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.inFlight, sequence)
+	if broadcast || m.next != sequence+1 {
+		return
+	}
+	m.next = sequence
+	if err := m.save(); err != nil {
+		log.Println(err)
+	}
+}
+
+// Mismatch handles a tx rejected because it was signed with got while the chain expects
+// expected. Returns whether the tx has to be signed again: a stale sequence is skipped past,
+// a gap left by sequences that never reached the chain is closed. When lower sequences are
+// still in flight the same tx is broadcast again once they reach the mempool.
+// A tx to sign again releases got.
+func (m *sequenceManager) Mismatch(got, expected uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if expected < got {
+		for s := range m.inFlight {
+			if s >= expected && s < got {
+				return false
+			}
+		}
+		m.next = expected
+	} else if expected > m.next {
+		m.next = expected
+	}
+	log.Println("Knstl: sequence", got, "is rejected, chain expects", expected, ", next is", m.next)
+	delete(m.inFlight, got)
+	if err := m.save(); err != nil {
+		log.Println(err)
+		m.loaded = false // reload from the chain on the next allocation
+	}
+	return true
+}
+
+// parseSequenceMismatch returns the expected and the signed sequence of a sequence mismatch log
+func parseSequenceMismatch(checkLog string) (uint64, uint64, error) {
+	match := sequenceMismatchRe.FindStringSubmatch(checkLog)
+	if match == nil {
+		return 0, 0, fmt.Errorf("not a sequence mismatch: %s", checkLog)
+	}
+	expected, err := strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	got, err := strconv.ParseUint(match[2], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return expected, got, nil
+}
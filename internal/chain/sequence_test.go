@@ -0,0 +1,64 @@
+package chain
+
+import "testing"
+
+func TestParseSequenceMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		log      string
+		expected uint64
+		got      uint64
+		wantErr  bool
+	}{
+		{
+			name:     "check tx log",
+			log:      "account sequence mismatch, expected 42, got 41: incorrect account sequence",
+			expected: 42,
+			got:      41,
+		},
+		{
+			name:     "broadcast error",
+			log:      "rpc error: code = Unknown desc = account sequence mismatch, expected 7, got 9: incorrect account sequence [cosmos/cosmos-sdk@v0.43.0/x/auth/ante/sigverify.go:264] with gas used: '33123': unknown request",
+			expected: 7,
+			got:      9,
+		},
+		{
+			name:     "zero sequences",
+			log:      "account sequence mismatch, expected 0, got 0",
+			expected: 0,
+			got:      0,
+		},
+		{
+			name:    "other check error",
+			log:     "insufficient fees; got: 10udarc required: 200udarc: insufficient fee",
+			wantErr: true,
+		},
+		{
+			name:    "empty log",
+			log:     "",
+			wantErr: true,
+		},
+		{
+			name:    "sequence out of range",
+			log:     "account sequence mismatch, expected 18446744073709551616, got 1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expected, got, err := parseSequenceMismatch(tt.log)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %d, %d, want an error", expected, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected != tt.expected || got != tt.got {
+				t.Fatalf("got expected %d, got %d, want %d, %d", expected, got, tt.expected, tt.got)
+			}
+		})
+	}
+}
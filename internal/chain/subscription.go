@@ -0,0 +1,91 @@
+package chain
+
+import (
+	"expvar"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/konstellation/swap/internal/util"
+)
+
+// SubscriptionStatus is the health of a chain event subscription,
+// reported on the health check and published as an expvar metric
+type SubscriptionStatus struct {
+	Connected   bool      `json:"connected"`
+	Reconnects  int64     `json:"reconnects"`
+	ConnectedAt time.Time `json:"connected_at"`
+	LastEventAt time.Time `json:"last_event_at"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+}
+
+// subscriptionHealth tracks the status of one subscription
+type subscriptionHealth struct {
+	mu     sync.Mutex
+	status SubscriptionStatus
+}
+
+// newSubscriptionHealth publishes the status under the metric name
+func newSubscriptionHealth(name string) *subscriptionHealth {
+	h := &subscriptionHealth{}
+	if expvar.Get(name) == nil {
+		expvar.Publish(name, expvar.Func(func() interface{} { return h.Status() }))
+	}
+	return h
+}
+
+// Connected records an established subscription, every one after the first is a reconnect
+func (h *subscriptionHealth) Connected() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.status.ConnectedAt.IsZero() {
+		h.status.Reconnects++
+	}
+	h.status.Connected = true
+	h.status.ConnectedAt = time.Now()
+}
+
+// Failed records a subscription or reconnect error
+func (h *subscriptionHealth) Failed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.Connected = false
+	if err != nil {
+		h.status.LastError = err.Error()
+	}
+	h.status.LastErrorAt = time.Now()
+}
+
+// Event records a received event
+func (h *subscriptionHealth) Event() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.LastEventAt = time.Now()
+}
+
+// Status returns a copy of the subscription status
+func (h *subscriptionHealth) Status() SubscriptionStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status
+}
+
+var (
+	jitterMu  sync.Mutex
+	jitterRnd = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// backoffDelay is the reconnect delay of the attempt, doubling from the minimum up to the
+// maximum, with half of it randomized so reconnecting clients do not retry in lockstep
+func backoffDelay(attempt int) time.Duration {
+	delay := time.Duration(util.ReconnectBackoffMaxSeconds) * time.Second
+	if attempt < 16 {
+		if d := time.Duration(util.ReconnectBackoffMinSeconds) * time.Second << uint(attempt); d < delay {
+			delay = d
+		}
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return delay/2 + time.Duration(jitterRnd.Int63n(int64(delay/2)+1))
+}
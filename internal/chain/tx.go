@@ -0,0 +1,25 @@
+package chain
+
+import (
+	"log"
+	"time"
+
+	"github.com/konstellation/swap/internal/model"
+	"github.com/konstellation/swap/internal/mongo"
+	"github.com/konstellation/swap/internal/util"
+)
+
+// updateTxStatus moves the swap to the next status and stores it
+func updateTxStatus(mg *mongo.Connection, tx *model.Tx, status model.TxStatus, reason string) error {
+	if err := tx.SetStatus(status, reason); err != nil {
+		log.Println(err)
+		return err
+	}
+	log.Printf("Swap %s status: %s (%s)\n", tx.ID.Hex(), status, reason)
+	tx.UpdatedAt = time.Now().Format(util.TimeFormat)
+	if _, err := mg.UpdateTx(tx); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
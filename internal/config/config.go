@@ -14,6 +14,10 @@ func (at AppType) String() string {
 
 const (
 	defaultPort = "1489"
+
+	defaultBscConfirmTimeoutMinutes = 30
+	defaultBscStuckMinutes          = 5
+	defaultBscGasBumpPercent        = 12.5
 )
 
 // Config struct contains main client config
@@ -27,6 +31,7 @@ type Config struct {
 	TLSEnable          bool
 	TLSCertLocation    string
 	TLSPrivKeyLocation string
+	OperatorToken      string
 	ReleaseInfo        *ReleaseInfo
 	SwapInfo           *SwapInfo
 	DB                 *DBConfig
@@ -50,6 +55,7 @@ func New() *Config {
 		TLSEnable:          tlsEnable,
 		TLSCertLocation:    os.Getenv("TLS_CERT_LOCATION"),
 		TLSPrivKeyLocation: os.Getenv("TLS_PRIV_KEY_LOCATION"),
+		OperatorToken:      os.Getenv("OPERATOR_TOKEN"),
 		Port:               GetPort(),
 		ReleaseInfo:        NewReleaseInfo(),
 		SwapInfo:           NewSwapInfo(),
@@ -67,3 +73,40 @@ func GetPort() string {
 	}
 	return port
 }
+
+// GetInt64 parses an integer env variable. Returns def when it is unset and 0 when it is invalid.
+func GetInt64(key string, def int64) int64 {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// GetFloat64 parses a decimal env variable. Returns def when it is unset and 0 when it is invalid.
+func GetFloat64(key string, def float64) float64 {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// GetList splits a comma separated env variable, empty entries are dropped
+func GetList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -1,6 +1,9 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+)
 
 type SwapInfo struct {
 	Knstl *KnstlInfo `json:"knstl"`
@@ -8,36 +11,116 @@ type SwapInfo struct {
 }
 
 type KnstlInfo struct {
-	KnstlNodeGrpcUrl  string `json:"knstl_node_grpc_url"`
-	KnstlNodeUrl      string `json:"knstl_node_url"`
-	KnstlSwapAddr     string `json:"knstl_swap_addr"`
-	KnstlSwapMnemonic string `json:"knstl_swap_mnemonic"`
+	KnstlNodeGrpcUrls           []string `json:"knstl_node_grpc_urls"`
+	KnstlGrpcTLS                bool     `json:"knstl_grpc_tls"`
+	KnstlNodeUrls               []string `json:"knstl_node_urls"`
+	KnstlMaxHeadLag             int64    `json:"knstl_max_head_lag"`
+	KnstlSwapAddr               string   `json:"knstl_swap_addr"`
+	KnstlSwapMnemonic           string   `json:"knstl_swap_mnemonic"`
+	KnstlKeyringBackend         string   `json:"knstl_keyring_backend"`
+	KnstlKeyringDir             string   `json:"knstl_keyring_dir"`
+	KnstlKeyringPassphraseFd    int      `json:"knstl_keyring_passphrase_fd"`
+	KnstlKeyringPassphraseFile  string   `json:"knstl_keyring_passphrase_file"`
+	KnstlKeyName                string   `json:"knstl_key_name"`
+	KnstlKeyArmorFile           string   `json:"knstl_key_armor_file"`
+	KnstlKeyArmorPassphraseFile string   `json:"knstl_key_armor_passphrase_file"`
+	KnstlStartHeight            int64    `json:"knstl_start_height"`
+	KnstlConfirmations          int64    `json:"knstl_confirmations"`
+	KnstlDenomDecimals          int      `json:"knstl_denom_decimals"`
+	KnstlGasAdjustment          float64  `json:"knstl_gas_adjustment"`
+	KnstlGasPrices              string   `json:"knstl_gas_prices"`
 }
 
 func NewKnstlInfo() *KnstlInfo {
+	startHeight, _ := strconv.ParseInt(os.Getenv("KNSTL_START_HEIGHT"), 10, 64)
+	confirmations, _ := strconv.ParseInt(os.Getenv("KNSTL_CONFIRMATIONS"), 10, 64)
+	denomDecimals, _ := strconv.Atoi(os.Getenv("KNSTL_DENOM_DECIMALS"))
+	knstlMaxHeadLag, _ := strconv.ParseInt(os.Getenv("KNSTL_MAX_HEAD_LAG"), 10, 64)
+	gasAdjustment, _ := strconv.ParseFloat(os.Getenv("KNSTL_GAS_ADJUSTMENT"), 64)
+	grpcTLS, _ := strconv.ParseBool(os.Getenv("KNSTL_GRPC_TLS"))
+	passphraseFd, _ := strconv.Atoi(os.Getenv("KNSTL_KEYRING_PASSPHRASE_FD"))
 	return &KnstlInfo{
-		KnstlNodeGrpcUrl:  os.Getenv("KNSTL_GRPC"),
-		KnstlNodeUrl:      os.Getenv("KNSTL_RPC"),
-		KnstlSwapAddr:     os.Getenv("KNSTL_CORPORATE_ADDR"),
-		KnstlSwapMnemonic: os.Getenv("KNSTL_SWAP_ADDR_MNEMONIC"),
+		KnstlNodeGrpcUrls:           GetList("KNSTL_GRPC"),
+		KnstlGrpcTLS:                grpcTLS,
+		KnstlNodeUrls:               GetList("KNSTL_RPC"),
+		KnstlMaxHeadLag:             knstlMaxHeadLag,
+		KnstlSwapAddr:               os.Getenv("KNSTL_CORPORATE_ADDR"),
+		KnstlSwapMnemonic:           os.Getenv("KNSTL_SWAP_ADDR_MNEMONIC"),
+		KnstlKeyringBackend:         os.Getenv("KNSTL_KEYRING_BACKEND"),
+		KnstlKeyringDir:             os.Getenv("KNSTL_KEYRING_DIR"),
+		KnstlKeyringPassphraseFd:    passphraseFd,
+		KnstlKeyringPassphraseFile:  os.Getenv("KNSTL_KEYRING_PASSPHRASE_FILE"),
+		KnstlKeyName:                os.Getenv("KNSTL_KEY_NAME"),
+		KnstlKeyArmorFile:           os.Getenv("KNSTL_KEY_ARMOR_FILE"),
+		KnstlKeyArmorPassphraseFile: os.Getenv("KNSTL_KEY_ARMOR_PASSPHRASE_FILE"),
+		KnstlStartHeight:            startHeight,
+		KnstlConfirmations:          confirmations,
+		KnstlDenomDecimals:          denomDecimals,
+		KnstlGasAdjustment:          gasAdjustment,
+		KnstlGasPrices:              os.Getenv("KNSTL_GAS_PRICES"),
 	}
 }
 
 type BscInfo struct {
-	BscTransactionScanApiUrl string `json:"bsc_transaction_scan_api_url"`
-	BscNodeUrl               string `json:"bsc_node_url"`
-	BEP20ContractAddr        string `json:"bep20_contract_addr"`
-	BscCorporateAddr         string `json:"bsc_corporate_addr"`
-	BscCorporateAddrPrivKey  string `json:"bsc_corporate_addr_priv_key"`
+	BscNodeUrls               []string `json:"bsc_node_urls"`
+	BscMaxHeadLag             int64    `json:"bsc_max_head_lag"`
+	BEP20ContractAddr         string   `json:"bep20_contract_addr"`
+	BscCorporateAddr          string   `json:"bsc_corporate_addr"`
+	BscCorporateAddrPrivKey   string   `json:"bsc_corporate_addr_priv_key"`
+	BscKeystoreFile           string   `json:"bsc_keystore_file"`
+	BscKeystorePassphraseFile string   `json:"bsc_keystore_passphrase_file"`
+	BscStartBlock             uint64   `json:"bsc_start_block"`
+	BscConfirmations          uint64   `json:"bsc_confirmations"`
+	BscTokenName              string   `json:"bsc_token_name"`
+	BscTokenSymbol            string   `json:"bsc_token_symbol"`
+	BscTokenDecimals          int      `json:"bsc_token_decimals"`
+	BscGasMarginPercent       uint64   `json:"bsc_gas_margin_percent"`
+	BscConfirmTimeoutMinutes  int64    `json:"bsc_confirm_timeout_minutes"`
+	BscChainID                string   `json:"bsc_chain_id"`
+	BscTxType                 string   `json:"bsc_tx_type"`
+	BscGasFeeCap              string   `json:"bsc_gas_fee_cap"`
+	BscGasTipCap              string   `json:"bsc_gas_tip_cap"`
+	BscStuckMinutes           int64    `json:"bsc_stuck_minutes"`
+	BscGasBumpPercent         float64  `json:"bsc_gas_bump_percent"`
+	BscGasPriceCeiling        string   `json:"bsc_gas_price_ceiling"`
+	BscDepositMnemonicFile    string   `json:"bsc_deposit_mnemonic_file"`
+	BscSweepMinutes           int64    `json:"bsc_sweep_minutes"`
 }
 
 func NewBscInfo() *BscInfo {
+	startBlock, _ := strconv.ParseUint(os.Getenv("BSC_START_BLOCK"), 10, 64)
+	confirmations, _ := strconv.ParseUint(os.Getenv("BSC_CONFIRMATIONS"), 10, 64)
+	tokenDecimals, _ := strconv.Atoi(os.Getenv("BSC_TOKEN_DECIMALS"))
+	gasMarginPercent, _ := strconv.ParseUint(os.Getenv("BSC_GAS_MARGIN_PERCENT"), 10, 64)
+	confirmTimeout := GetInt64("BSC_CONFIRM_TIMEOUT_MINUTES", defaultBscConfirmTimeoutMinutes)
+	bscMaxHeadLag, _ := strconv.ParseInt(os.Getenv("BSC_MAX_HEAD_LAG"), 10, 64)
+	stuckMinutes := GetInt64("BSC_STUCK_MINUTES", defaultBscStuckMinutes)
+	gasBumpPercent := GetFloat64("BSC_GAS_BUMP_PERCENT", defaultBscGasBumpPercent)
+	sweepMinutes, _ := strconv.ParseInt(os.Getenv("BSC_SWEEP_MINUTES"), 10, 64)
 	return &BscInfo{
-		BscTransactionScanApiUrl: os.Getenv("BSC_TRANSACTION_API_URL"),
-		BscNodeUrl:               os.Getenv("BSC_RPC"),
-		BEP20ContractAddr:        os.Getenv("BSC_BEP20_CONTRACT_ADDR"),
-		BscCorporateAddr:         os.Getenv("BSC_CORPORATE_ADDR"),
-		BscCorporateAddrPrivKey:  os.Getenv("BSC_CORPORATE_ADDR_PRIV_KEY"),
+		BscNodeUrls:               GetList("BSC_RPC"),
+		BscMaxHeadLag:             bscMaxHeadLag,
+		BEP20ContractAddr:         os.Getenv("BSC_BEP20_CONTRACT_ADDR"),
+		BscCorporateAddr:          os.Getenv("BSC_CORPORATE_ADDR"),
+		BscCorporateAddrPrivKey:   os.Getenv("BSC_CORPORATE_ADDR_PRIV_KEY"),
+		BscKeystoreFile:           os.Getenv("BSC_KEYSTORE_FILE"),
+		BscKeystorePassphraseFile: os.Getenv("BSC_KEYSTORE_PASSPHRASE_FILE"),
+		BscStartBlock:             startBlock,
+		BscConfirmations:          confirmations,
+		BscTokenName:              os.Getenv("BSC_TOKEN_NAME"),
+		BscTokenSymbol:            os.Getenv("BSC_TOKEN_SYMBOL"),
+		BscTokenDecimals:          tokenDecimals,
+		BscGasMarginPercent:       gasMarginPercent,
+		BscConfirmTimeoutMinutes:  confirmTimeout,
+		BscChainID:                os.Getenv("BSC_CHAIN_ID"),
+		BscTxType:                 os.Getenv("BSC_TX_TYPE"),
+		BscGasFeeCap:              os.Getenv("BSC_GAS_FEE_CAP"),
+		BscGasTipCap:              os.Getenv("BSC_GAS_TIP_CAP"),
+		BscStuckMinutes:           stuckMinutes,
+		BscGasBumpPercent:         gasBumpPercent,
+		BscGasPriceCeiling:        os.Getenv("BSC_GAS_PRICE_CEILING"),
+		BscDepositMnemonicFile:    os.Getenv("BSC_DEPOSIT_MNEMONIC_FILE"),
+		BscSweepMinutes:           sweepMinutes,
 	}
 }
 
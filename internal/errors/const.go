@@ -18,6 +18,10 @@ const (
 	ECTxInsertFailed = 11000
 	ECTxSelectFailed = 11001
 
+	ECRefundSelectFailed  = 11100
+	ECRefundApproveFailed = 11101
+	ECUnauthorized        = 11200
+
 	ECInvalidID = 10105
 )
 
@@ -30,6 +34,9 @@ var (
 		ECInvalidID:           http.StatusBadRequest,
 		ECTxInsertFailed:      http.StatusInternalServerError,
 		ECTxSelectFailed:      http.StatusInternalServerError,
+		ECRefundSelectFailed:  http.StatusInternalServerError,
+		ECRefundApproveFailed: http.StatusInternalServerError,
+		ECUnauthorized:        http.StatusUnauthorized,
 	}
 
 	CodeText = map[int]string{
@@ -42,5 +49,9 @@ var (
 
 		ECTxInsertFailed: "failed to insert new tx",
 		ECTxSelectFailed: "failed to select tx",
+
+		ECRefundSelectFailed:  "failed to select refund",
+		ECRefundApproveFailed: "failed to approve refund",
+		ECUnauthorized:        "unauthorized",
 	}
 )
@@ -0,0 +1,154 @@
+package keyring
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	keyringdb "github.com/99designs/keyring"
+	"github.com/cosmos/cosmos-sdk/codec"
+	"github.com/cosmos/cosmos-sdk/codec/legacy"
+	"github.com/cosmos/cosmos-sdk/crypto"
+	cryptocodec "github.com/cosmos/cosmos-sdk/crypto/codec"
+	"github.com/cosmos/cosmos-sdk/crypto/hd"
+	cryptokeyring "github.com/cosmos/cosmos-sdk/crypto/keyring"
+	cryptotypes "github.com/cosmos/cosmos-sdk/crypto/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	keyringFileDir = "keyring-file"
+	keyhashFile    = "keyhash"
+	infoSuffix     = ".info"
+	addressSuffix  = ".address"
+)
+
+// storedKey mirrors the local key record of the cosmos keyring
+type storedKey struct {
+	Name         string             `json:"name"`
+	PubKey       cryptotypes.PubKey `json:"pubkey"`
+	PrivKeyArmor string             `json:"privkey.armor"`
+	Algo         hd.PubKeyType      `json:"algo"`
+}
+
+var storedKeyCdc = newStoredKeyCodec()
+
+func newStoredKeyCodec() *codec.LegacyAmino {
+	cdc := codec.NewLegacyAmino()
+	cryptocodec.RegisterCrypto(cdc)
+	cdc.RegisterConcrete(storedKey{}, "crypto/keys/localInfo", nil)
+	return cdc
+}
+
+// fileKeyring holds the keys of a cosmos file keyring in memory. The file is unlocked with
+// the given passphrase, never with a prompt. Keys created or imported are written back to it.
+type fileKeyring struct {
+	cryptokeyring.Keyring
+	db keyringdb.Keyring
+}
+
+// openFileKeyring opens the keyring-file directory of dir, the one `keys --keyring-backend file` uses
+func openFileKeyring(serviceName, dir, passphrase string) (cryptokeyring.Keyring, error) {
+	fileDir := filepath.Join(dir, keyringFileDir)
+	db, err := keyringdb.Open(keyringdb.Config{
+		AllowedBackends:  []keyringdb.BackendType{keyringdb.FileBackend},
+		ServiceName:      serviceName,
+		FileDir:          fileDir,
+		FilePasswordFunc: filePassphrase(fileDir, passphrase),
+	})
+	if err != nil {
+		return nil, err
+	}
+	kb := &fileKeyring{Keyring: cryptokeyring.NewInMemory(), db: db}
+	if err := kb.load(passphrase); err != nil {
+		return nil, err
+	}
+	return kb, nil
+}
+
+// filePassphrase checks the passphrase against the keyhash of the keyring, a new keyring stores it
+func filePassphrase(dir, passphrase string) keyringdb.PromptFunc {
+	return func(string) (string, error) {
+		path := filepath.Join(dir, keyhashFile)
+		keyhash, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := bcrypt.CompareHashAndPassword(keyhash, []byte(passphrase)); err != nil {
+				return "", errors.New("incorrect keyring passphrase")
+			}
+			return passphrase, nil
+		case os.IsNotExist(err):
+			keyhash, err = bcrypt.GenerateFromPassword([]byte(passphrase), bcrypt.DefaultCost)
+			if err != nil {
+				return "", err
+			}
+			return passphrase, os.WriteFile(path, keyhash, 0600)
+		default:
+			return "", fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	}
+}
+
+// load copies the local keys of the file into memory. Ledger, offline and multisig keys
+// cannot sign and are skipped.
+func (kb *fileKeyring) load(passphrase string) error {
+	keys, err := kb.db.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, infoSuffix) {
+			continue
+		}
+		item, err := kb.db.Get(key)
+		if err != nil {
+			return err
+		}
+		var k storedKey
+		if err := storedKeyCdc.UnmarshalLengthPrefixed(item.Data, &k); err != nil {
+			continue
+		}
+		priv, err := legacy.PrivKeyFromBytes([]byte(k.PrivKeyArmor))
+		if err != nil {
+			return fmt.Errorf("failed to read key %s: %v", k.Name, err)
+		}
+		armor := crypto.EncryptArmorPrivKey(priv, passphrase, string(k.Algo))
+		if err := kb.Keyring.ImportPrivKey(k.Name, armor, passphrase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewAccount derives the key from the mnemonic and writes it to the file
+func (kb *fileKeyring) NewAccount(uid, mnemonic, bip39Passphrase, hdPath string, algo cryptokeyring.SignatureAlgo) (cryptokeyring.Info, error) {
+	info, err := kb.Keyring.NewAccount(uid, mnemonic, bip39Passphrase, hdPath, algo)
+	if err != nil {
+		return nil, err
+	}
+	return info, kb.write(info)
+}
+
+// ImportPrivKey imports the armored key and writes it to the file
+func (kb *fileKeyring) ImportPrivKey(uid, armor, passphrase string) error {
+	if err := kb.Keyring.ImportPrivKey(uid, armor, passphrase); err != nil {
+		return err
+	}
+	info, err := kb.Keyring.Key(uid)
+	if err != nil {
+		return err
+	}
+	return kb.write(info)
+}
+
+// write stores the key the way the cosmos keyring does, under its name and its address
+func (kb *fileKeyring) write(info cryptokeyring.Info) error {
+	key := info.GetName() + infoSuffix
+	if err := kb.db.Set(keyringdb.Item{Key: key, Data: legacy.Cdc.MustMarshalLengthPrefixed(info)}); err != nil {
+		return err
+	}
+	return kb.db.Set(keyringdb.Item{Key: hex.EncodeToString(info.GetAddress()) + addressSuffix, Data: []byte(key)})
+}
@@ -1,14 +1,17 @@
 package keyring
 
 import (
-	"bufio"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"os"
 	"strings"
+	"sync"
 
 	"github.com/cosmos/cosmos-sdk/crypto/hd"
 	cryptokeyring "github.com/cosmos/cosmos-sdk/crypto/keyring"
 	sdk "github.com/cosmos/cosmos-sdk/types"
+	sdkerrors "github.com/cosmos/cosmos-sdk/types/errors"
 	bip39 "github.com/cosmos/go-bip39"
 )
 
@@ -57,21 +60,88 @@ const (
 	Bech32PrefixConsPub = Bech32MainPrefix + PrefixValidator + PrefixConsensus + PrefixPublic
 )
 
-func NewKeyring(serviceName string) (cryptokeyring.Keyring, error) {
-	kb, err := cryptokeyring.New(serviceName, cryptokeyring.BackendMemory, keyringDir, bufio.NewReader(strings.NewReader("")))
+// NewKeyring opens the keyring of the backend, memory, file or test. The file backend is
+// unlocked with passphrase, it never prompts on the terminal.
+func NewKeyring(serviceName, backend, dir, passphrase string) (cryptokeyring.Keyring, error) {
+	if backend == "" {
+		backend = cryptokeyring.BackendMemory
+	}
+	if dir == "" {
+		dir = keyringDir
+	}
+	switch backend {
+	case cryptokeyring.BackendMemory, cryptokeyring.BackendTest:
+		return cryptokeyring.New(serviceName, backend, dir, nil)
+	case cryptokeyring.BackendFile:
+		if passphrase == "" {
+			return nil, errors.New("file keyring requires a passphrase")
+		}
+		return openFileKeyring(serviceName, dir, passphrase)
+	default:
+		return nil, fmt.Errorf("unsupported keyring backend %s", backend)
+	}
+}
+
+// ReadPassphrase reads a passphrase from the file descriptor when fd is positive, otherwise
+// from the secret file. Returns an empty passphrase when neither is set.
+func ReadPassphrase(fd int, file string) (string, error) {
+	var (
+		b   []byte
+		err error
+	)
+	switch {
+	case fd > 0:
+		f := os.NewFile(uintptr(fd), "passphrase")
+		if f == nil {
+			return "", fmt.Errorf("invalid passphrase fd %d", fd)
+		}
+		b, err = io.ReadAll(f)
+		f.Close()
+	case file != "":
+		b, err = os.ReadFile(file)
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// LoadKey returns the key stored in the keyring under name. A missing key is imported from
+// the armor file or, failing that, recovered from the mnemonic.
+func LoadKey(kb cryptokeyring.Keyring, name, armorFile, armorPassphrase, mnemonic string) (cryptokeyring.Info, error) {
+	RegisterBech32Prefix()
+	info, err := kb.Key(name)
+	if err == nil {
+		return info, nil
+	}
+	if !sdkerrors.ErrKeyNotFound.Is(err) {
+		return nil, err
+	}
+	if armorFile != "" {
+		return ImportArmoredKey(kb, name, armorFile, armorPassphrase)
+	}
+	if mnemonic != "" {
+		return CreateKey(kb, name, mnemonic)
+	}
+	return nil, fmt.Errorf("key %s is not in the keyring and neither an armor file nor a mnemonic is set", name)
+}
+
+// ImportArmoredKey imports the armored private key exported with `keys export`
+func ImportArmoredKey(kb cryptokeyring.Keyring, name, armorFile, passphrase string) (cryptokeyring.Info, error) {
+	RegisterBech32Prefix()
+	armor, err := os.ReadFile(armorFile)
 	if err != nil {
 		return nil, err
 	}
-	return kb, nil
+	if err := kb.ImportPrivKey(name, string(armor), passphrase); err != nil {
+		return nil, err
+	}
+	return kb.Key(name)
 }
 
 func CreateKey(kb cryptokeyring.Keyring, name, mnemonic string) (cryptokeyring.Info, error) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Println(err)
-		}
-	}()
-
 	RegisterBech32Prefix()
 	cryptokeyringAlgos, _ := kb.SupportedAlgorithms()
 	algo, err := cryptokeyring.NewSigningAlgoFromString(algoStr, cryptokeyringAlgos)
@@ -97,10 +167,15 @@ func CreateKey(kb cryptokeyring.Keyring, name, mnemonic string) (cryptokeyring.I
 	return info, nil
 }
 
+var registerBech32Prefix sync.Once
+
+// RegisterBech32Prefix sets the darc prefixes and seals the sdk config, only the first call has effect
 func RegisterBech32Prefix() {
-	config := sdk.GetConfig()
-	config.SetBech32PrefixForAccount(Bech32PrefixAccAddr, Bech32PrefixAccPub)
-	config.SetBech32PrefixForValidator(Bech32PrefixValAddr, Bech32PrefixValPub)
-	config.SetBech32PrefixForConsensusNode(Bech32PrefixConsAddr, Bech32PrefixConsPub)
-	config.Seal()
+	registerBech32Prefix.Do(func() {
+		config := sdk.GetConfig()
+		config.SetBech32PrefixForAccount(Bech32PrefixAccAddr, Bech32PrefixAccPub)
+		config.SetBech32PrefixForValidator(Bech32PrefixValAddr, Bech32PrefixValPub)
+		config.SetBech32PrefixForConsensusNode(Bech32PrefixConsAddr, Bech32PrefixConsPub)
+		config.Seal()
+	})
 }
@@ -0,0 +1,40 @@
+package keyring
+
+import (
+	"testing"
+
+	cryptokeyring "github.com/cosmos/cosmos-sdk/crypto/keyring"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art"
+
+func TestFileKeyring(t *testing.T) {
+	dir := t.TempDir()
+	kb, err := NewKeyring("portal", cryptokeyring.BackendFile, dir, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := LoadKey(kb, "swap", "", "", testMnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kb, err = NewKeyring("portal", cryptokeyring.BackendFile, dir, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadKey(kb, "swap", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.GetAddress().Equals(created.GetAddress()) {
+		t.Fatalf("got address %s, want %s", loaded.GetAddress(), created.GetAddress())
+	}
+	if _, _, err := kb.Sign("swap", []byte("msg")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewKeyring("portal", cryptokeyring.BackendFile, dir, "wrong"); err == nil {
+		t.Fatal("opened the keyring with a wrong passphrase")
+	}
+}
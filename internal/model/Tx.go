@@ -1,6 +1,9 @@
 package model
 
 import (
+	"crypto/rand"
+	"encoding/base32"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -8,18 +11,27 @@ type Tx struct {
 	ID                          primitive.ObjectID `bson:"_id"`
 	FromAddress                 string             `json:"from_address" bson:"from_address"`
 	ToAddress                   string             `json:"to_address" bson:"to_address"`
+	Reference                   string             `json:"reference" bson:"reference"`
 	SourceNetwork               string             `json:"source_network" bson:"source_network"`
+	DepositAddress              string             `json:"deposit_address" bson:"deposit_address"`
 	SourceNetworkHash           string             `json:"source_network_hash" bson:"source_network_hash"`
+	SourceNetworkIndex          int                `json:"source_network_index" bson:"source_network_index"`
+	SourceNetworkHeight         uint64             `json:"source_network_height" bson:"source_network_height"`
+	SourceSender                string             `json:"source_sender" bson:"source_sender"` // sender of the deposit, refunds go back to it
 	DestinationNetwork          string             `json:"destination_network" bson:"destination_network"`
 	DestinationNetworkHash      string             `json:"destination_network_hash" bson:"destination_network_hash"`
-	Amount                      float64            `json:"amount" bson:"amount"`
+	ReplacementHashes           []string           `json:"replacement_hashes" bson:"replacement_hashes"`
+	RefundHash                  string             `json:"refund_hash" bson:"refund_hash"`
+	RefundStatus                string             `json:"refund_status" bson:"refund_status"`
+	Amount                      string             `json:"amount" bson:"amount"`
 	Timestamp                   uint64             `json:"timestamp" bson:"timestamp"`
 	SourceNetworkCompleted      bool               `json:"source_network_completed" bson:"source_network_completed"`
 	DestinationNetworkCompleted bool               `json:"destination_network_completed" bson:"destination_network_completed"`
 	Completed                   bool               `json:"completed" bson:"completed"`
+	Status                      TxStatus           `json:"status" bson:"status"`
+	StatusHistory               []StatusChange     `json:"status_history" bson:"status_history"`
 	CreatedAt                   string             `json:"created_at" bson:"created_at"`
 	UpdatedAt                   string             `json:"updated_at" bson:"updated_at"`
-	TxTryCount                  int                `json:"tx_try_count" bson:"tx_try_count"`
 }
 
 func NewTx() *Tx {
@@ -27,3 +39,12 @@ func NewTx() *Tx {
 		ID: primitive.NewObjectID(),
 	}
 }
+
+// NewReference returns a random swap reference code, the user puts it in the memo of the knstl deposit
+func NewReference() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	// CursorBscLogs is the last bsc block whose Transfer logs are stored
+	CursorBscLogs = "bsc_logs"
+	// CursorKnstlTxs is the last knstl block whose deposits are processed
+	CursorKnstlTxs = "knstl_txs"
+)
+
+// Cursor keeps the last processed block height of a chain listener
+type Cursor struct {
+	ID        string    `bson:"_id"`
+	Height    uint64    `json:"height" bson:"height"`
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DepositAddress is the bsc address derived for one bsc->knstl swap. Deposits to it
+// belong to that swap and are swept into BSC_CORPORATE_ADDR afterwards.
+type DepositAddress struct {
+	ID        string             `bson:"_id"` // hex address
+	Index     uint32             `json:"index" bson:"index"`
+	TxID      primitive.ObjectID `json:"tx_id" bson:"tx_id"`
+	Swept     bool               `json:"swept" bson:"swept"`   // a sweep tx succeeded, later deposits are swept again
+	Closed    bool               `json:"closed" bson:"closed"` // empty, settled and past the retention, no longer swept
+	SweepHash string             `json:"sweep_hash" bson:"sweep_hash"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+// CounterDepositIndex counts the derived deposit addresses
+const CounterDepositIndex = "bsc_deposit_index"
+
+// Counter is a named sequence
+type Counter struct {
+	ID   string `bson:"_id"`
+	Next uint32 `json:"next" bson:"next"`
+}
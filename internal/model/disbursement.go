@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DisbursementIntent    = "intent"
+	DisbursementSigned    = "signed"
+	DisbursementBroadcast = "broadcast"
+	DisbursementConfirmed = "confirmed"
+)
+
+// Disbursement records the payout of a swap. It is keyed by the swap id so a
+// swap can never have two payouts; once signed, the same raw tx is re-broadcast.
+type Disbursement struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Network   string             `json:"network" bson:"network"`
+	Status    string             `json:"status" bson:"status"`
+	RawTx     string             `json:"raw_tx" bson:"raw_tx"`
+	Hash      string             `json:"hash" bson:"hash"`
+	Hashes    []string           `json:"hashes" bson:"hashes"` // every broadcast fee level, the last one is Hash
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+func NewDisbursement(txID primitive.ObjectID, network string) *Disbursement {
+	now := time.Now()
+	return &Disbursement{
+		ID:        txID,
+		Network:   network,
+		Status:    DisbursementIntent,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// QueueBscSwap holds bsc->knstl swap requests waiting for a matching deposit
+	QueueBscSwap = "bsc_swap"
+	// QueueBscPayout holds knstl->bsc swaps whose deposit is confirmed, waiting for the bsc payout
+	QueueBscPayout = "bsc_payout"
+	// QueueKnstlPayout holds bsc->knstl swaps whose deposit is confirmed, waiting for the knstl payout
+	QueueKnstlPayout = "knstl_payout"
+
+	JobStatusPending = "pending"
+	JobStatusDone    = "done"
+	JobStatusDead    = "dead"
+)
+
+// Job is a persistent unit of work for a swap. A job is leased by a worker
+// until LeasedUntil; if the worker dies the lease expires and the job is
+// picked up again.
+type Job struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Queue       string             `json:"queue" bson:"queue"`
+	TxID        primitive.ObjectID `json:"tx_id" bson:"tx_id"`
+	Status      string             `json:"status" bson:"status"`
+	Attempts    int                `json:"attempts" bson:"attempts"`
+	MaxAttempts int                `json:"max_attempts" bson:"max_attempts"`
+	NextRunAt   time.Time          `json:"next_run_at" bson:"next_run_at"`
+	LeasedUntil time.Time          `json:"leased_until" bson:"leased_until"`
+	LastError   string             `json:"last_error" bson:"last_error"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+func NewJob(queue string, txID primitive.ObjectID, maxAttempts int) *Job {
+	now := time.Now()
+	return &Job{
+		ID:          primitive.NewObjectID(),
+		Queue:       queue,
+		TxID:        txID,
+		Status:      JobStatusPending,
+		MaxAttempts: maxAttempts,
+		NextRunAt:   now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+}
@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// Nonce is the next nonce (bsc) or account sequence (knstl) to sign with for an address
+type Nonce struct {
+	ID        string    `bson:"_id"`
+	Next      uint64    `json:"next" bson:"next"`
+	Signed    uint64    `json:"signed" bson:"signed"` // one past the highest nonce with a stored signed tx
+	UpdatedAt time.Time `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RefundPendingApproval = "pending_approval"
+	RefundApproved        = "approved"
+	RefundSigned          = "signed"
+	RefundBroadcast       = "broadcast"
+	RefundConfirmed       = "confirmed"
+	RefundFailed          = "failed"
+)
+
+// Refund records a deposit the swap keeps no payout for: unmatched, rejected or left behind
+// by an expired swap. An operator approves it, then the deposit minus Fee is sent back to
+// the sender on the source network. A deposit has at most one refund.
+type Refund struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	TxID         primitive.ObjectID `json:"tx_id" bson:"tx_id"` // zero when no swap matches the deposit
+	Network      string             `json:"network" bson:"network"`
+	DepositHash  string             `json:"deposit_hash" bson:"deposit_hash"`
+	DepositIndex int                `json:"deposit_index" bson:"deposit_index"`
+	Sender       string             `json:"sender" bson:"sender"`
+	Amount       string             `json:"amount" bson:"amount"`
+	Fee          string             `json:"fee" bson:"fee"`
+	Reason       string             `json:"reason" bson:"reason"`
+	Status       string             `json:"status" bson:"status"`
+	ApprovedBy   string             `json:"approved_by" bson:"approved_by"`
+	ApprovedAt   time.Time          `json:"approved_at" bson:"approved_at"`
+	RawTx        string             `json:"raw_tx" bson:"raw_tx"`
+	Hash         string             `json:"hash" bson:"hash"`
+	LastError    string             `json:"last_error" bson:"last_error"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
+}
+
+func NewRefund(network, depositHash string, depositIndex int) *Refund {
+	now := time.Now()
+	return &Refund{
+		ID:           primitive.NewObjectID(),
+		Network:      network,
+		DepositHash:  depositHash,
+		DepositIndex: depositIndex,
+		Status:       RefundPendingApproval,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+type TxStatus string
+
+const (
+	StatusRequested       TxStatus = "requested"
+	StatusSourceSeen      TxStatus = "source_seen"
+	StatusSourceConfirmed TxStatus = "source_confirmed"
+	StatusDisbursing      TxStatus = "disbursing"
+	StatusDisbursed       TxStatus = "disbursed"
+	StatusConfirmed       TxStatus = "confirmed"
+	StatusFailed          TxStatus = "failed"
+	StatusRefunded        TxStatus = "refunded"
+	StatusExpired         TxStatus = "expired"
+	StatusRejected        TxStatus = "rejected"
+)
+
+// statusTransitions lists the legal next states of every state
+var statusTransitions = map[TxStatus][]TxStatus{
+	StatusRequested:       {StatusSourceSeen, StatusExpired, StatusRejected, StatusFailed},
+	StatusSourceSeen:      {StatusSourceConfirmed, StatusRejected, StatusFailed},
+	StatusSourceConfirmed: {StatusDisbursing, StatusRejected, StatusFailed},
+	StatusDisbursing:      {StatusDisbursed, StatusFailed},
+	StatusDisbursed:       {StatusConfirmed, StatusFailed},
+	StatusFailed:          {StatusRefunded},
+	StatusExpired:         {StatusRefunded},
+	StatusRejected:        {StatusRefunded},
+}
+
+// StatusChange is an entry of the swap status history
+type StatusChange struct {
+	Status TxStatus  `json:"status" bson:"status"`
+	Reason string    `json:"reason" bson:"reason"`
+	At     time.Time `json:"at" bson:"at"`
+}
+
+func (s TxStatus) String() string {
+	return string(s)
+}
+
+// IsTerminal reports whether the swap flow is over in this state
+func (s TxStatus) IsTerminal() bool {
+	switch s {
+	case StatusConfirmed, StatusFailed, StatusRefunded, StatusExpired, StatusRejected:
+		return true
+	}
+	return false
+}
+
+// CanTransitionTo reports whether moving from s to next is legal.
+// Swaps stored before statuses existed have an empty status and are treated as requested.
+func (s TxStatus) CanTransitionTo(next TxStatus) bool {
+	if s == "" {
+		if next == StatusRequested {
+			return true
+		}
+		s = StatusRequested
+	}
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStatus moves the swap to the next state and appends it to the history.
+// The legacy completion flags are kept in sync with the state.
+func (t *Tx) SetStatus(next TxStatus, reason string) error {
+	if !t.Status.CanTransitionTo(next) {
+		return fmt.Errorf("tx %s: illegal status transition %q -> %q", t.ID.Hex(), t.Status, next)
+	}
+	t.Status = next
+	t.StatusHistory = append(t.StatusHistory, StatusChange{
+		Status: next,
+		Reason: reason,
+		At:     time.Now(),
+	})
+
+	switch next {
+	case StatusSourceConfirmed, StatusDisbursing, StatusDisbursed:
+		t.SourceNetworkCompleted = true
+	case StatusConfirmed:
+		t.SourceNetworkCompleted = true
+		t.DestinationNetworkCompleted = true
+	}
+	t.Completed = next.IsTerminal()
+	return nil
+}
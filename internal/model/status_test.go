@@ -0,0 +1,106 @@
+package model
+
+import "testing"
+
+func TestCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from TxStatus
+		to   TxStatus
+		want bool
+	}{
+		// swaps stored before statuses existed are requested swaps
+		{"", StatusRequested, true},
+		{"", StatusSourceSeen, true},
+		{"", StatusExpired, true},
+		{"", StatusSourceConfirmed, false},
+		{"", StatusDisbursing, false},
+		{"", StatusConfirmed, false},
+		{"", StatusRefunded, false},
+
+		{StatusRequested, StatusSourceSeen, true},
+		{StatusRequested, StatusExpired, true},
+		{StatusRequested, StatusRejected, true},
+		{StatusRequested, StatusFailed, true},
+		{StatusRequested, StatusRequested, false},
+		{StatusRequested, StatusSourceConfirmed, false},
+		{StatusRequested, StatusDisbursing, false},
+
+		{StatusSourceSeen, StatusSourceConfirmed, true},
+		{StatusSourceSeen, StatusRejected, true},
+		{StatusSourceSeen, StatusFailed, true},
+		{StatusSourceSeen, StatusSourceSeen, false},
+		{StatusSourceSeen, StatusExpired, false},
+		{StatusSourceSeen, StatusDisbursing, false},
+
+		{StatusSourceConfirmed, StatusDisbursing, true},
+		{StatusSourceConfirmed, StatusRejected, true},
+		{StatusSourceConfirmed, StatusFailed, true},
+		{StatusSourceConfirmed, StatusSourceSeen, false},
+		{StatusSourceConfirmed, StatusDisbursed, false},
+
+		// a payout in flight can never go back to an earlier state
+		{StatusDisbursing, StatusDisbursed, true},
+		{StatusDisbursing, StatusFailed, true},
+		{StatusDisbursing, StatusSourceSeen, false},
+		{StatusDisbursing, StatusSourceConfirmed, false},
+		{StatusDisbursing, StatusRejected, false},
+		{StatusDisbursing, StatusRefunded, false},
+		{StatusDisbursing, StatusConfirmed, false},
+
+		{StatusDisbursed, StatusConfirmed, true},
+		{StatusDisbursed, StatusFailed, true},
+		{StatusDisbursed, StatusDisbursing, false},
+		{StatusDisbursed, StatusRefunded, false},
+
+		// terminal states
+		{StatusConfirmed, StatusFailed, false},
+		{StatusConfirmed, StatusRefunded, false},
+		{StatusConfirmed, StatusDisbursing, false},
+		{StatusFailed, StatusRefunded, true},
+		{StatusFailed, StatusDisbursing, false},
+		{StatusFailed, StatusSourceSeen, false},
+		{StatusExpired, StatusRefunded, true},
+		{StatusExpired, StatusSourceSeen, false},
+		{StatusRejected, StatusRefunded, true},
+		{StatusRejected, StatusSourceConfirmed, false},
+		{StatusRefunded, StatusRefunded, false},
+		{StatusRefunded, StatusFailed, false},
+
+		{"unknown", StatusSourceSeen, false},
+	}
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Errorf("%q -> %q: got %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	tx := NewTx()
+	for _, next := range []TxStatus{StatusRequested, StatusSourceSeen, StatusSourceConfirmed, StatusDisbursing, StatusDisbursed} {
+		if err := tx.SetStatus(next, "test"); err != nil {
+			t.Fatalf("SetStatus(%q): %v", next, err)
+		}
+		if tx.Completed {
+			t.Fatalf("%q: completed before the payout is confirmed", next)
+		}
+	}
+	if !tx.SourceNetworkCompleted || tx.DestinationNetworkCompleted {
+		t.Fatalf("disbursed: source completed %v, destination completed %v", tx.SourceNetworkCompleted, tx.DestinationNetworkCompleted)
+	}
+	if err := tx.SetStatus(StatusSourceSeen, "test"); err == nil {
+		t.Fatal("disbursed -> source_seen is accepted")
+	}
+	if tx.Status != StatusDisbursed {
+		t.Fatalf("rejected transition changed the status to %q", tx.Status)
+	}
+	if err := tx.SetStatus(StatusConfirmed, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if !tx.Completed || !tx.DestinationNetworkCompleted {
+		t.Fatal("confirmed swap is not completed")
+	}
+	if len(tx.StatusHistory) != 6 {
+		t.Fatalf("history has %d entries, want 6", len(tx.StatusHistory))
+	}
+}
@@ -0,0 +1,34 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/konstellation/swap/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetCursor returns mongo.ErrNoDocuments if the cursor was never saved
+func (c *Connection) GetCursor(name string) (*model.Cursor, error) {
+	var cursor model.Cursor
+	cursors := c.DB.Collection("cursors")
+	err := cursors.FindOne(c.Ctx, bson.M{"_id": name}).Decode(&cursor)
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// SaveCursor moves the cursor forward to height. A lower height is ignored.
+func (c *Connection) SaveCursor(name string, height uint64) error {
+	cursors := c.DB.Collection("cursors")
+	opts := options.Update().SetUpsert(true)
+	filter := bson.D{primitive.E{Key: "_id", Value: name}}
+	update := bson.D{
+		primitive.E{Key: "$max", Value: bson.D{primitive.E{Key: "height", Value: height}}},
+		primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "updated_at", Value: time.Now()}}},
+	}
+	_, err := cursors.UpdateOne(c.Ctx, filter, update, opts)
+	return err
+}
@@ -0,0 +1,69 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/konstellation/swap/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NextCounter increments the named sequence and returns the value before the increment
+func (c *Connection) NextCounter(name string) (uint32, error) {
+	var counter model.Counter
+	counters := c.DB.Collection("counters")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	filter := bson.D{primitive.E{Key: "_id", Value: name}}
+	update := bson.D{primitive.E{Key: "$inc", Value: bson.D{primitive.E{Key: "next", Value: 1}}}}
+	err := counters.FindOneAndUpdate(c.Ctx, filter, update, opts).Decode(&counter)
+	if err == mongo.ErrNoDocuments { // first value of a new sequence
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return counter.Next, nil
+}
+
+func (c *Connection) InsertDepositAddress(d *model.DepositAddress) (interface{}, error) {
+	addresses := c.DB.Collection("deposit_addresses")
+	d.CreatedAt = time.Now()
+	d.UpdatedAt = d.CreatedAt
+	result, err := addresses.InsertOne(c.Ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	return result.InsertedID, nil
+}
+
+// FindDepositAddresses returns the deposit addresses, only the ones not closed yet when open is set
+func (c *Connection) FindDepositAddresses(open bool) ([]model.DepositAddress, error) {
+	var result []model.DepositAddress
+	addresses := c.DB.Collection("deposit_addresses")
+	filter := bson.D{}
+	if open { // addresses stored before closing existed have no closed field
+		filter = append(filter, primitive.E{Key: "closed", Value: bson.D{{Key: "$ne", Value: true}}})
+	}
+	cur, err := addresses.Find(c.Ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(c.Ctx)
+	if err := cur.All(c.Ctx, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Connection) UpdateDepositAddress(d *model.DepositAddress) (interface{}, error) {
+	addresses := c.DB.Collection("deposit_addresses")
+	d.UpdatedAt = time.Now()
+	filter := bson.D{primitive.E{Key: "_id", Value: d.ID}}
+	result, err := addresses.ReplaceOne(c.Ctx, filter, d)
+	if err != nil {
+		return nil, err
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,41 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/konstellation/swap/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (c *Connection) InsertDisbursement(d *model.Disbursement) (interface{}, error) {
+	disbursements := c.DB.Collection("disbursements")
+	result, err := disbursements.InsertOne(c.Ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.InsertedID, nil
+}
+
+func (c *Connection) GetDisbursement(txID primitive.ObjectID) (*model.Disbursement, error) {
+	var d model.Disbursement
+	disbursements := c.DB.Collection("disbursements")
+	err := disbursements.FindOne(c.Ctx, bson.M{"_id": txID}).Decode(&d)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (c *Connection) UpdateDisbursement(d *model.Disbursement) (interface{}, error) {
+	disbursements := c.DB.Collection("disbursements")
+	d.UpdatedAt = time.Now()
+	filter := bson.D{primitive.E{Key: "_id", Value: d.ID}}
+	result, err := disbursements.UpdateOne(c.Ctx, filter, bson.D{primitive.E{Key: "$set", Value: d}})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,94 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/konstellation/swap/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (c *Connection) EnsureJobIndexes() error {
+	jobs := c.DB.Collection("jobs")
+	_, err := jobs.Indexes().CreateMany(c.Ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "queue", Value: 1}, {Key: "tx_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "queue", Value: 1}, {Key: "status", Value: 1}, {Key: "next_run_at", Value: 1}},
+		},
+	})
+	return err
+}
+
+// EnqueueJob stores the job unless the queue already has one for the same tx. A done or dead
+// job of the tx is put back to pending, callers only enqueue swaps that are still open.
+func (c *Connection) EnqueueJob(job *model.Job) (interface{}, error) {
+	jobs := c.DB.Collection("jobs")
+	opts := options.Update().SetUpsert(true)
+	filter := bson.D{
+		primitive.E{Key: "queue", Value: job.Queue},
+		primitive.E{Key: "tx_id", Value: job.TxID},
+	}
+	result, err := jobs.UpdateOne(c.Ctx, filter, bson.D{primitive.E{Key: "$setOnInsert", Value: job}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result.UpsertedID != nil {
+		return result.UpsertedID, nil
+	}
+
+	finished := append(filter, primitive.E{Key: "status", Value: bson.M{"$in": []string{model.JobStatusDone, model.JobStatusDead}}})
+	reset := bson.D{primitive.E{Key: "$set", Value: bson.D{
+		primitive.E{Key: "status", Value: model.JobStatusPending},
+		primitive.E{Key: "attempts", Value: 0},
+		primitive.E{Key: "next_run_at", Value: job.NextRunAt},
+		primitive.E{Key: "leased_until", Value: time.Time{}},
+		primitive.E{Key: "updated_at", Value: time.Now()},
+	}}}
+	if _, err := jobs.UpdateOne(c.Ctx, finished, reset); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// LeaseJob takes the next due job of the queue for the lease duration.
+// Returns mongo.ErrNoDocuments when nothing is due.
+func (c *Connection) LeaseJob(queue string, lease time.Duration) (*model.Job, error) {
+	var job model.Job
+	jobs := c.DB.Collection("jobs")
+	now := time.Now()
+	filter := bson.D{
+		primitive.E{Key: "queue", Value: queue},
+		primitive.E{Key: "status", Value: model.JobStatusPending},
+		primitive.E{Key: "next_run_at", Value: bson.M{"$lte": now}},
+		primitive.E{Key: "leased_until", Value: bson.M{"$lte": now}},
+	}
+	update := bson.D{primitive.E{Key: "$set", Value: bson.D{
+		primitive.E{Key: "leased_until", Value: now.Add(lease)},
+		primitive.E{Key: "updated_at", Value: now},
+	}}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{primitive.E{Key: "next_run_at", Value: 1}}).
+		SetReturnDocument(options.After)
+	err := jobs.FindOneAndUpdate(c.Ctx, filter, update, opts).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (c *Connection) UpdateJob(job *model.Job) (interface{}, error) {
+	jobs := c.DB.Collection("jobs")
+	job.UpdatedAt = time.Now()
+	filter := bson.D{primitive.E{Key: "_id", Value: job.ID}}
+	result, err := jobs.UpdateOne(c.Ctx, filter, bson.D{primitive.E{Key: "$set", Value: job}})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,54 @@
+package mongo
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MigrateTxAmounts rewrites the numeric amounts of swaps stored before amounts
+// were decimal strings. Returns the number of migrated swaps.
+func (c *Connection) MigrateTxAmounts() (int, error) {
+	txs := c.DB.Collection("txs")
+	cur, err := txs.Find(c.Ctx, bson.M{"amount": bson.M{"$type": "number"}})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(c.Ctx)
+
+	migrated := 0
+	for cur.Next(c.Ctx) {
+		var doc struct {
+			ID     primitive.ObjectID `bson:"_id"`
+			Amount interface{}        `bson:"amount"`
+		}
+		if err := cur.Decode(&doc); err != nil {
+			return migrated, err
+		}
+		var amount decimal.Decimal
+		switch v := doc.Amount.(type) {
+		case float64:
+			amount = decimal.NewFromFloat(v)
+		case int32:
+			amount = decimal.NewFromInt32(v)
+		case int64:
+			amount = decimal.NewFromInt(v)
+		case primitive.Decimal128:
+			amount, err = decimal.NewFromString(v.String())
+			if err != nil {
+				return migrated, err
+			}
+		default:
+			return migrated, fmt.Errorf("tx %s: unexpected amount type %T", doc.ID.Hex(), doc.Amount)
+		}
+		filter := bson.D{primitive.E{Key: "_id", Value: doc.ID}}
+		update := bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "amount", Value: amount.String()}}}}
+		if _, err := txs.UpdateOne(c.Ctx, filter, update); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cur.Err()
+}
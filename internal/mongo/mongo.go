@@ -3,7 +3,6 @@ package mongo
 import (
 	"context"
 	"fmt"
-	"math"
 	"strconv"
 
 	"github.com/ethereum/go-ethereum/core/types"
@@ -59,21 +58,44 @@ func (c *Connection) GetTx(id primitive.ObjectID) (interface{}, error) {
 	return tx, nil
 }
 
+// EnsureTxIndexes makes the swap reference codes unique, swaps created before they existed have none
+func (c *Connection) EnsureTxIndexes() error {
+	txs := c.DB.Collection("txs")
+	_, err := txs.Indexes().CreateOne(c.Ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "reference", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "reference", Value: bson.M{"$gt": ""}}}),
+	})
+	return err
+}
+
 func (c *Connection) FindTx(where map[string]string) (interface{}, error) {
 	var tx model.Tx
-	var filter bson.D
 	txs := c.DB.Collection("txs")
-	for condition, value := range where {
-		if condition == "amount" {
-			val, _ := strconv.ParseFloat(value, 64)
-			if val == math.Trunc(val) {
-				filter = append(filter, bson.E{Key: condition, Value: uint64(val)})
-			} else {
-				filter = append(filter, bson.E{Key: condition, Value: val})
-			}
+	err := txs.FindOne(c.Ctx, txFilter(where)).Decode(&tx)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
 
-			continue
-		}
+func (c *Connection) FindTxs(where map[string]string) ([]model.Tx, error) {
+	var result []model.Tx
+	txs := c.DB.Collection("txs")
+	cur, err := txs.Find(c.Ctx, txFilter(where))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(c.Ctx)
+	if err := cur.All(c.Ctx, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func txFilter(where map[string]string) bson.D {
+	var filter bson.D
+	for condition, value := range where {
 		if condition == "source_network_completed" {
 			val, _ := strconv.ParseBool(value)
 			filter = append(filter, bson.E{Key: condition, Value: val})
@@ -89,13 +111,14 @@ func (c *Connection) FindTx(where map[string]string) (interface{}, error) {
 			filter = append(filter, bson.E{Key: condition, Value: val})
 			continue
 		}
+		if condition == "source_network_index" {
+			val, _ := strconv.Atoi(value)
+			filter = append(filter, bson.E{Key: condition, Value: val})
+			continue
+		}
 		filter = append(filter, bson.E{Key: condition, Value: value})
 	}
-	err := txs.FindOne(c.Ctx, filter).Decode(&tx)
-	if err != nil {
-		return nil, err
-	}
-	return tx, nil
+	return filter
 }
 
 func (c *Connection) UpdateTx(tx *model.Tx) (interface{}, error) {
@@ -135,14 +158,20 @@ func (c *Connection) FindBlacklist(where map[string]string) (interface{}, error)
 	return address, nil
 }
 
+// InsertBscTx stores the log once. Logs seen again by the backfill are skipped.
 func (c *Connection) InsertBscTx(bscTx *types.Log) (interface{}, error) {
-	blacklist := c.DB.Collection("bsctxs")
-	result, err := blacklist.InsertOne(c.Ctx, bscTx)
+	bsctxs := c.DB.Collection("bsctxs")
+	opts := options.Update().SetUpsert(true)
+	filter := bson.D{
+		primitive.E{Key: "txhash", Value: bscTx.TxHash},
+		primitive.E{Key: "index", Value: bscTx.Index},
+	}
+	result, err := bsctxs.UpdateOne(c.Ctx, filter, bson.D{primitive.E{Key: "$setOnInsert", Value: bscTx}}, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return result.InsertedID, nil
+	return result.UpsertedID, nil
 }
 
 func (c *Connection) FindBscTx(where map[string]string) (*mongo.Cursor, error) {
@@ -167,7 +196,10 @@ func (c *Connection) UpdateBscTx(bscTx *types.Log) (interface{}, error) {
 	// Reference: https://pkg.go.dev/go.mongodb.org/mongo-driver/mongo#Collection.UpdateOne
 	bsctxs := c.DB.Collection("bsctxs")
 	opts := options.Update().SetUpsert(true)
-	filter := bson.D{primitive.E{Key: "blocknumber", Value: bscTx.BlockNumber}}
+	filter := bson.D{
+		primitive.E{Key: "txhash", Value: bscTx.TxHash},
+		primitive.E{Key: "index", Value: bscTx.Index},
+	}
 	result, err := bsctxs.UpdateOne(c.Ctx, filter, bson.D{primitive.E{Key: "$set", Value: bscTx}}, opts)
 	if err != nil {
 		return nil, err
@@ -175,3 +207,18 @@ func (c *Connection) UpdateBscTx(bscTx *types.Log) (interface{}, error) {
 
 	return result.UpsertedID, nil
 }
+
+// ConsumeBscTx takes the log out of the deposits waiting for a swap and records the swap paid from it
+func (c *Connection) ConsumeBscTx(bscTx *types.Log, txID primitive.ObjectID) error {
+	bsctxs := c.DB.Collection("bsctxs")
+	filter := bson.D{
+		primitive.E{Key: "txhash", Value: bscTx.TxHash},
+		primitive.E{Key: "index", Value: bscTx.Index},
+	}
+	update := bson.D{primitive.E{Key: "$set", Value: bson.D{
+		primitive.E{Key: "removed", Value: true},
+		primitive.E{Key: "swap_id", Value: txID},
+	}}}
+	_, err := bsctxs.UpdateOne(c.Ctx, filter, update)
+	return err
+}
@@ -0,0 +1,30 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/konstellation/swap/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetNonce returns mongo.ErrNoDocuments if no nonce was allocated for the address
+func (c *Connection) GetNonce(address string) (*model.Nonce, error) {
+	var nonce model.Nonce
+	nonces := c.DB.Collection("nonces")
+	err := nonces.FindOne(c.Ctx, bson.M{"_id": address}).Decode(&nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &nonce, nil
+}
+
+func (c *Connection) SaveNonce(nonce *model.Nonce) error {
+	nonces := c.DB.Collection("nonces")
+	nonce.UpdatedAt = time.Now()
+	opts := options.Update().SetUpsert(true)
+	filter := bson.D{primitive.E{Key: "_id", Value: nonce.ID}}
+	_, err := nonces.UpdateOne(c.Ctx, filter, bson.D{primitive.E{Key: "$set", Value: nonce}}, opts)
+	return err
+}
@@ -0,0 +1,108 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/konstellation/swap/internal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (c *Connection) EnsureRefundIndexes() error {
+	refunds := c.DB.Collection("refunds")
+	_, err := refunds.Indexes().CreateMany(c.Ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "network", Value: 1}, {Key: "deposit_hash", Value: 1}, {Key: "deposit_index", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "network", Value: 1}, {Key: "status", Value: 1}},
+		},
+	})
+	return err
+}
+
+// RecordRefund stores the refund unless the deposit already has one.
+// Returns the stored refund of the deposit.
+func (c *Connection) RecordRefund(r *model.Refund) (*model.Refund, error) {
+	var stored model.Refund
+	refunds := c.DB.Collection("refunds")
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	filter := bson.D{
+		primitive.E{Key: "network", Value: r.Network},
+		primitive.E{Key: "deposit_hash", Value: r.DepositHash},
+		primitive.E{Key: "deposit_index", Value: r.DepositIndex},
+	}
+	err := refunds.FindOneAndUpdate(c.Ctx, filter, bson.D{primitive.E{Key: "$setOnInsert", Value: r}}, opts).Decode(&stored)
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+func (c *Connection) GetRefund(id primitive.ObjectID) (*model.Refund, error) {
+	var r model.Refund
+	refunds := c.DB.Collection("refunds")
+	err := refunds.FindOne(c.Ctx, bson.M{"_id": id}).Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (c *Connection) FindRefunds(where map[string]string) ([]model.Refund, error) {
+	var result []model.Refund
+	var filter bson.D
+	for condition, value := range where {
+		filter = append(filter, bson.E{Key: condition, Value: value})
+	}
+	refunds := c.DB.Collection("refunds")
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "created_at", Value: 1}})
+	cur, err := refunds.Find(c.Ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(c.Ctx)
+	if err := cur.All(c.Ctx, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ApproveRefund moves a refund waiting for approval to approved.
+// Returns mongo.ErrNoDocuments when it is not waiting for approval.
+func (c *Connection) ApproveRefund(id primitive.ObjectID, operator string) (*model.Refund, error) {
+	var r model.Refund
+	refunds := c.DB.Collection("refunds")
+	now := time.Now()
+	filter := bson.D{
+		primitive.E{Key: "_id", Value: id},
+		primitive.E{Key: "status", Value: model.RefundPendingApproval},
+	}
+	update := bson.D{primitive.E{Key: "$set", Value: bson.D{
+		primitive.E{Key: "status", Value: model.RefundApproved},
+		primitive.E{Key: "approved_by", Value: operator},
+		primitive.E{Key: "approved_at", Value: now},
+		primitive.E{Key: "updated_at", Value: now},
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := refunds.FindOneAndUpdate(c.Ctx, filter, update, opts).Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (c *Connection) UpdateRefund(r *model.Refund) (interface{}, error) {
+	refunds := c.DB.Collection("refunds")
+	r.UpdatedAt = time.Now()
+	filter := bson.D{primitive.E{Key: "_id", Value: r.ID}}
+	result, err := refunds.UpdateOne(c.Ctx, filter, bson.D{primitive.E{Key: "$set", Value: r}})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.ModifiedCount, nil
+}
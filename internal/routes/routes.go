@@ -1,11 +1,11 @@
 package routes
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
@@ -15,6 +15,7 @@ import (
 	"github.com/konstellation/swap/internal/model"
 	"github.com/konstellation/swap/internal/util"
 	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -30,6 +31,27 @@ func InitRoutes(e *echo.Echo) {
 	e.GET("/tx/:id", getTx)
 	e.GET("/log", getLog)
 	//e.DELETE("/tx/:id", abortTx)
+
+	refunds := e.Group("/refunds", operatorAuth)
+	refunds.GET("", getRefunds)
+	refunds.POST("/:id/approve", approveRefund)
+}
+
+// operatorAuth lets through the requests bearing OPERATOR_TOKEN, the operator routes are off without one
+func operatorAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		cctx := ctx.Get("cctx").(*httpserver.CCtx)
+		token := cctx.GetConfig().OperatorToken
+		auth := ctx.Request().Header.Get(echo.HeaderAuthorization)
+		if token == "" || subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			err := errors.PreparePayload(errors.ECUnauthorized, "operator token required")
+			return ctx.JSON(http.StatusUnauthorized, &Response{
+				Result:  err.Error(),
+				Success: false,
+			})
+		}
+		return next(ctx)
+	}
 }
 
 func addTx(ctx echo.Context) error {
@@ -55,7 +77,7 @@ func addTx(ctx echo.Context) error {
 
 	log.Printf("POST request: %+v\n", *i)
 
-	if numDecPlaces(i.Amount) > 5 {
+	if numDecPlaces(i.Amount) > util.AmountMaxDecimals {
 		err := errors.PreparePayload(errors.ECTxInsertFailed, fmt.Sprintf("The %s amount has to be less than 5 decimals like 0.00001", i.FromNetwork))
 		log.Println(err)
 		return ctx.JSON(http.StatusOK, &Response{
@@ -64,9 +86,9 @@ func addTx(ctx echo.Context) error {
 		})
 	}
 
-	var fee float64
+	fee := decimal.Zero
 	if i.ToNetwork == "bsc" {
-		fee = float64(util.UserBscTransactionFee)
+		fee = decimal.RequireFromString(util.UserBscTransactionFee)
 	}
 	decimalAmount := util.GetTransactionAmount(i.ToNetwork, i.Amount)
 	log.Println("user transaction destination network", i.ToNetwork, "total amount:", decimalAmount)
@@ -122,29 +144,56 @@ func addTx(ctx echo.Context) error {
 			})
 		}
 		log.Println("3 minutes timeout passed")
-		tx.Completed = true
+		if err := tx.SetStatus(model.StatusExpired, "replaced by a new request from the same address"); err != nil {
+			// its deposit is already seen, the former swap goes on and no competing request is made
+			log.Println(err)
+			err := errors.PreparePayload(errors.ECTxInsertFailed, fmt.Sprintf("Former transaction is in progress (status: %s)", tx.Status))
+			return ctx.JSON(http.StatusOK, &Response{
+				Result:  err.Error(),
+				Success: false,
+			})
+		}
 		_, err = cctx.MongoDB.UpdateTx(&tx)
 		if err != nil {
+			err := errors.PreparePayload(errors.ECTxInsertFailed, err)
 			log.Println(err)
+			return ctx.JSON(http.StatusOK, &Response{
+				Result:  err.Error(),
+				Success: false,
+			})
 		}
 		log.Printf("Update old result %+v completed status to true. Because 3 minute timeout passed", result)
 	}
 
 	log.Printf("Preparing requeset insertion into DB")
 	tx := model.NewTx()
+	tx.Reference, err = model.NewReference()
+	if err != nil {
+		err := errors.PreparePayload(errors.ECTxInsertFailed, err)
+		log.Println(err)
+		return ctx.JSON(http.StatusOK, &Response{
+			Result:  err.Error(),
+			Success: false,
+		})
+	}
 	tx.FromAddress = i.FromAddress
 	tx.ToAddress = i.ToAddress
 	tx.SourceNetwork = i.FromNetwork
 	tx.DestinationNetwork = i.ToNetwork
-	tx.Amount = i.Amount
+	tx.Amount = i.Amount.String()
 	tx.CreatedAt = time.Now().Format(util.TimeFormat)
 	tx.UpdatedAt = tx.CreatedAt
-
-	go func() {
-		if tx.SourceNetwork == "bsc" {
-			cctx.BscConn.InputChan <- tx
+	_ = tx.SetStatus(model.StatusRequested, "swap requested")
+	if tx.SourceNetwork == bsc {
+		if err := cctx.BscConn.AssignDepositAddress(tx); err != nil {
+			err := errors.PreparePayload(errors.ECTxInsertFailed, err)
+			log.Println(err)
+			return ctx.JSON(http.StatusOK, &Response{
+				Result:  err.Error(),
+				Success: false,
+			})
 		}
-	}()
+	}
 
 	_, err = cctx.MongoDB.InsertTx(tx)
 	if err != nil {
@@ -155,6 +204,11 @@ func addTx(ctx echo.Context) error {
 			Success: false,
 		})
 	}
+	if tx.SourceNetwork == bsc {
+		if err := cctx.BscConn.Enqueue(tx); err != nil { // picked up again by ResumeSwaps on restart
+			log.Println(err)
+		}
+	}
 	log.Printf("====== POST request is inserted in DB: %+v ======\n ", *tx)
 	return ctx.JSON(http.StatusOK, &Response{
 		Result:  tx,
@@ -269,20 +323,86 @@ func getTx(ctx echo.Context) error {
 		})
 	}
 	log.Printf("transaction conversion is successful: %+v\n", tx)
-	errMsgLogFormat := "====== %s ======"
 
-	if !tx.Completed {
-		errMsg := "Knstl transaction is on the process"
-		log.Printf(errMsgLogFormat, errMsg)
+	// swaps in progress are returned too, completed, status and status_history tell where they are
+	log.Printf("====== Found transaction with status %s: %+v ======\n", tx.Status, tx)
+	return ctx.JSON(http.StatusOK, &Response{
+		Result:  tx,
+		Success: true,
+	})
+}
+
+func getRefunds(ctx echo.Context) error {
+	log.Println("====== Get GET refunds request ======")
+	cctx := ctx.Get("cctx").(*httpserver.CCtx)
+
+	filter := map[string]string{}
+	for _, key := range []string{"network", "status"} {
+		if value := ctx.QueryParam(key); value != "" {
+			filter[key] = value
+		}
+	}
+	refunds, err := cctx.MongoDB.FindRefunds(filter)
+	if err != nil {
+		err := errors.PreparePayload(errors.ECRefundSelectFailed, err)
+		log.Println(err)
+		return ctx.JSON(http.StatusOK, &Response{
+			Result:  err.Error(),
+			Success: false,
+		})
+	}
+	return ctx.JSON(http.StatusOK, &Response{
+		Result:  refunds,
+		Success: true,
+	})
+}
+
+func approveRefund(ctx echo.Context) error {
+	log.Println("====== Get POST refund approval request ======")
+	cctx := ctx.Get("cctx").(*httpserver.CCtx)
+
+	id := ctx.Param("id")
+	objectId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		err := errors.PreparePayload(errors.ECInvalidID, err)
+		log.Println(err)
+		return ctx.JSON(http.StatusOK, &Response{
+			Result:  err.Error(),
+			Success: false,
+		})
+	}
+	ra := new(RefundApproval)
+	if err := ctx.Bind(ra); err != nil {
+		err = errors.Prepare(errors.ECBindError, err)
+		log.Println(err)
+		return ctx.JSON(http.StatusOK, &Response{
+			Result:  err.Error(),
+			Success: false,
+		})
+	}
+	if err := ra.Validate(); err != nil {
+		log.Println(err)
 		return ctx.JSON(http.StatusOK, &Response{
-			Result:  errMsg,
+			Result:  err.Error(),
 			Success: false,
 		})
 	}
 
-	log.Printf("====== Found complete transaction: %+v ======\n", tx)
+	refund, err := cctx.MongoDB.ApproveRefund(objectId, ra.Operator)
+	if err == mongo.ErrNoDocuments {
+		err = fmt.Errorf("refund %s is not waiting for approval", id)
+	}
+	if err != nil {
+		err := errors.PreparePayload(errors.ECRefundApproveFailed, err)
+		log.Println(err)
+		return ctx.JSON(http.StatusOK, &Response{
+			Result:  err.Error(),
+			Success: false,
+		})
+	}
+	log.Printf("====== Refund %s approved by %s ======\n", id, ra.Operator)
 	return ctx.JSON(http.StatusOK, &Response{
-		Result:  tx,
+		Result:  refund,
 		Success: true,
 	})
 }
@@ -297,8 +417,8 @@ func getLog(ctx echo.Context) error {
 	return ctx.HTML(http.StatusOK, strings.Replace(logContent, "\n", "<br>", -1))
 }
 
-func numDecPlaces(v float64) int {
-	s := strconv.FormatFloat(v, 'f', -1, 64)
+func numDecPlaces(v decimal.Decimal) int {
+	s := v.String()
 	i := strings.IndexByte(s, '.')
 	if i > -1 {
 		return len(s) - i - 1
@@ -5,14 +5,15 @@ import (
 	"regexp"
 
 	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/shopspring/decimal"
 )
 
 type TxInput struct {
-	FromAddress string  `json:"from_address"`
-	FromNetwork string  `json:"from_network"`
-	ToAddress   string  `json:"to_address"`
-	ToNetwork   string  `json:"to_network"`
-	Amount      float64 `json:"amount"`
+	FromAddress string          `json:"from_address"`
+	FromNetwork string          `json:"from_network"`
+	ToAddress   string          `json:"to_address"`
+	ToNetwork   string          `json:"to_network"`
+	Amount      decimal.Decimal `json:"amount"`
 }
 
 // Validate struct
@@ -28,6 +29,9 @@ func (i TxInput) Validate() error {
 	} else if i.ToNetwork == "knstl" && len(i.ToAddress) > 0 && !knstlRe.MatchString(i.ToAddress) {
 		return fmt.Errorf("Not valid knstl address: %s", i.ToAddress)
 	}
+	if !i.Amount.IsPositive() {
+		return fmt.Errorf("Not valid amount: %s", i.Amount)
+	}
 
 	return validation.ValidateStruct(&i,
 		validation.Field(
@@ -46,10 +50,6 @@ func (i TxInput) Validate() error {
 			&i.ToNetwork,
 			validation.Required,
 		),
-		validation.Field(
-			&i.Amount,
-			validation.Required,
-		),
 	)
 }
 
@@ -72,3 +72,17 @@ func (br BlacklistRequest) Validate() error {
 	}
 	return nil
 }
+
+type RefundApproval struct {
+	Operator string `json:"operator"`
+}
+
+// Validate struct
+func (ra RefundApproval) Validate() error {
+	return validation.ValidateStruct(&ra,
+		validation.Field(
+			&ra.Operator,
+			validation.Required,
+		),
+	)
+}
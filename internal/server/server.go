@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"net/http"
 	"os"
@@ -29,9 +30,18 @@ import (
 // @Produce json
 // @Router / [get]
 func healthCheck(ctx echo.Context) error {
+	cctx := ctx.Get("cctx").(*httpserver.CCtx)
 	return ctx.JSON(http.StatusOK, map[string]interface{}{
 		"name":    os.Getenv("APP_NAME"),
 		"success": true,
+		"nodes": map[string]interface{}{
+			"bsc":   cctx.BscConn.Endpoints(),
+			"knstl": cctx.KnstlConn.Endpoints(),
+		},
+		"subscriptions": map[string]interface{}{
+			"bsc":   cctx.BscConn.Subscription(),
+			"knstl": cctx.KnstlConn.Subscription(),
+		},
 	})
 }
 
@@ -144,6 +154,7 @@ func (s *Server) init() *Server {
 	//e.Static("/", "static")
 
 	echopprof.Wrap(e)
+	e.GET("/debug/vars", echo.WrapHandler(expvar.Handler())) // subscription metrics
 
 	routes.InitRoutes(e)
 
@@ -2,31 +2,45 @@ package util
 
 import (
 	"log"
+	"math/big"
 
 	"github.com/shopspring/decimal"
 )
 
 const (
-	UserBscTransactionFee   = 2
-	UserKnstlTransactionFee = 0.0001
+	UserBscTransactionFee   = "2"
+	UserKnstlTransactionFee = "0.0001"
 
-	AmountBscUnit                 = "1000000000000000000"
-	AmountKnstlUnit               = "1000000"
+	AmountMaxDecimals             = 5
 	BlacklistAllowThresholdAmount = "1000000"
 )
 
-func GetTransactionAmount(network string, amount float64) decimal.Decimal {
-	var amountUnit string
+// Token decimals, replaced at startup by the ones read from the chains
+var (
+	AmountBscDecimals   int32 = 18
+	AmountKnstlDecimals int32 = 6
+)
+
+// GetTransactionAmount converts a token amount into the base units of the network
+func GetTransactionAmount(network string, amount decimal.Decimal) decimal.Decimal {
+	decimals := networkDecimals(network)
+	log.Println(network, "unit decimals:", decimals)
+	log.Println("User transaction decimal amount:", amount)
+	userTransactionTotalFee := amount.Shift(decimals)
+	log.Println("User transaction total", network, "amount:", userTransactionTotalFee)
+	return userTransactionTotalFee
+}
+
+// FromBaseUnits converts an amount in base units of the network into a token amount
+func FromBaseUnits(network string, amount *big.Int) decimal.Decimal {
+	return decimal.NewFromBigInt(amount, -networkDecimals(network))
+}
+
+func networkDecimals(network string) int32 {
 	if network == "bsc" {
-		amountUnit = AmountBscUnit
+		return AmountBscDecimals
 	} else if network == "knstl" {
-		amountUnit = AmountKnstlUnit
+		return AmountKnstlDecimals
 	}
-	unitDecimalAmount, _ := decimal.NewFromString(amountUnit)
-	log.Println(network, "unit decimal amount:", unitDecimalAmount)
-	userTransactionDecimalFee := decimal.NewFromFloat(amount)
-	log.Println("User transaction decimal amount:", userTransactionDecimalFee)
-	userTransactionTotalFee := userTransactionDecimalFee.Mul(unitDecimalAmount)
-	log.Println("User transaction total", network, "amount:", userTransactionTotalFee)
-	return userTransactionTotalFee
+	return 0
 }
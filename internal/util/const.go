@@ -0,0 +1,18 @@
+package util
+
+const (
+	// swap job queue
+	JobMaxAttempts = 20
+
+	// bsc log backfill
+	BscBackfillBlockRange = 5000
+
+	// knstl tx backfill
+	KnstlBackfillPerPage = 100
+	KnstlEventBuffer     = 100
+	// broadcasts of a payout rejected for its account sequence
+	KnstlSequenceRetries = 5
+
+	// a bsc deposit no open swap matches for this many blocks (about an hour) is orphaned
+	BscOrphanBlocks = 1200
+)
@@ -4,4 +4,39 @@ const (
 	TimeFormat       = "2006-01-02 15:04:05.999999999 -0700 MST"
 	TimeoutMinute    = 20
 	SleepTimeSeconds = 15
+
+	// swap job queue
+	JobPollSeconds  = 5
+	JobRetryMinutes = 1
+	JobLeaseMinutes = 10
+
+	// deadline of every call on the shared grpc connection
+	KnstlGrpcCallTimeoutSeconds = 15
+	// nodes drop clients pinging more often than every 5 minutes
+	KnstlGrpcKeepaliveSeconds        = 300
+	KnstlGrpcKeepaliveTimeoutSeconds = 20
+	// resubscribe when no new block arrives for this long
+	KnstlHeartbeatTimeoutSeconds = 60
+	// wait between broadcasts of a payout rejected for its account sequence
+	KnstlSequenceRetrySeconds = 2
+
+	// node endpoint failover
+	EndpointProbeSeconds        = 30
+	EndpointProbeTimeoutSeconds = 10
+
+	// subscription reconnect backoff
+	ReconnectBackoffMinSeconds = 1
+	ReconnectBackoffMaxSeconds = 120
+
+	// a knstl payout not in a block by then is left to the recheck
+	KnstlConfirmTimeoutMinutes = 30
+
+	// open payouts are run again this often
+	DisbursementRecheckMinutes = 5
+
+	// deposit addresses are swept for this long after their swap is created
+	DepositRetentionDays = 30
+
+	// refunds of orphaned deposits
+	RefundPollSeconds = 30
 )